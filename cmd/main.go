@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"database/sql"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,11 +13,17 @@ import (
 
 	"million-rps/internal/cache"
 	"million-rps/internal/config"
+	"million-rps/internal/controller"
 	"million-rps/internal/database"
+	"million-rps/internal/metrics"
+	"million-rps/internal/outbox"
 	"million-rps/internal/queue"
+	"million-rps/internal/repository"
 	"million-rps/internal/routes"
 	"million-rps/internal/worker"
 	"million-rps/pkg/logger"
+
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -25,26 +32,59 @@ func main() {
 	ctx := context.Background()
 	config.Get()
 
-	// Initialize DB pool (required for workers and cache miss path)
+	// Initialize DB pool (required for workers and cache miss path); waits for Postgres if it is not up yet
 	db := database.InitDB(ctx)
 	if db == nil {
 		logger.Error(ctx, "Database not available; exiting")
 		os.Exit(1)
 	}
-	if err := database.MigrateOrCreateSchema(ctx); err != nil {
+	if err := database.Migrate(ctx); err != nil {
 		logger.Error(ctx, "Schema migration failed", "error", err)
 		os.Exit(1)
 	}
 
-	// Pre-warm Redis (optional; cache works lazily)
+	// Pre-warm Redis (optional; the cache connects lazily and retries with backoff until Redis is up)
 	cache.Client(ctx)
 
-	// Pre-warm Kafka producer and ensure topic exists
-	queue.Producer(ctx)
-	queue.EnsureTopic(ctx)
+	// Serve hot keys from the in-process L1 while subscribed to the workers' invalidations (CACHE_L1_TTL_MS)
+	l1Ctx, stopL1 := context.WithCancel(ctx)
+	go cache.SubscribeInvalidations(l1Ctx)
+
+	// Pool gauges for /metrics (read on each scrape)
+	metrics.RegisterDBStats(func() *sql.DB { return database.DB(ctx) })
+	metrics.RegisterRedisPoolStats(func() *redis.Client { return cache.Client(ctx) })
+
+	// Open the command queue (QUEUE_BACKEND); for Kafka this pre-warms the producer and ensures the topic exists.
+	commands, err := queue.Open(ctx)
+	if err != nil {
+		logger.Error(ctx, "Command queue unavailable; exiting", "error", err)
+		os.Exit(1)
+	}
+	store := repository.Postgres{}
+	controller.Use(store, cache.Redis{}, commands)
+	worker.Use(store, cache.Redis{}, commands)
+
+	// In outbox mode handlers store commands in Postgres and the relay publishes them to the queue.
+	relayCtx, stopRelay := context.WithCancel(ctx)
+	relayDone := make(chan struct{})
+	if config.Get().OutboxEnabled {
+		controller.Use(store, cache.Redis{}, outbox.Publisher{Store: store})
+		go func() {
+			defer close(relayDone)
+			outbox.Relay(relayCtx, store, commands)
+		}()
+	} else {
+		close(relayDone)
+	}
 
-	// Start worker pool in background (consumes Kafka, writes to DB, invalidates cache)
-	go worker.Run(ctx)
+	// Start worker pool in background (consumes the queue, writes to DB, invalidates cache).
+	// workerCtx is cancelled on shutdown; workerDone closes once in-flight messages are settled.
+	workerCtx, stopWorker := context.WithCancel(ctx)
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		worker.Run(workerCtx)
+	}()
 
 	server := &http.Server{
 		Addr:         ":" + config.Get().HTTPPort,
@@ -64,13 +104,53 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	logger.Info(ctx, "Shutting down server")
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	logger.Info(ctx, "Shutting down", "timeout_sec", config.Get().ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Get().ShutdownTimeout)*time.Second)
 	defer cancel()
+
+	// 1. Stop accepting HTTP and drain in-flight requests (no new commands after this).
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		logger.Error(ctx, "Server shutdown error", "error", err)
 	}
 	logger.Info(ctx, "Server stopped")
+
+	// 2. Stop the outbox relay (unsent rows stay in Postgres), then flush queued async publishes.
+	stopRelay()
+	shutdownStep(shutdownCtx, "Outbox relay", func() error { <-relayDone; return nil })
+	shutdownStep(shutdownCtx, "Command queue flush", commands.Flush)
+
+	// 3. Stop fetching; each worker lane finishes its current message and settled offsets are committed.
+	stopWorker()
+	select {
+	case <-workerDone:
+		logger.Info(ctx, "Worker stopped")
+	case <-shutdownCtx.Done():
+		logger.Error(ctx, "Worker did not stop before shutdown deadline; uncommitted messages will be redelivered")
+	}
+	shutdownStep(shutdownCtx, "Command queue", commands.Close)
+
+	// 4. Release Redis and Postgres connections.
+	stopL1()
+	shutdownStep(shutdownCtx, "Redis client", cache.Close)
+	shutdownStep(shutdownCtx, "Database pool", database.Close)
+	logger.Info(ctx, "Shutdown complete")
+}
+
+// shutdownStep runs fn but gives up (leaving it running) when ctx expires, so one stuck dependency
+// cannot hold the process past the shutdown deadline.
+func shutdownStep(ctx context.Context, name string, fn func() error) {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			logger.Error(ctx, "Shutdown step failed", "step", name, "error", err)
+			return
+		}
+		logger.Info(ctx, "Shutdown step complete", "step", name)
+	case <-ctx.Done():
+		logger.Error(ctx, "Shutdown step timed out", "step", name)
+	}
 }
 
 // loadEnvFile reads a .env file and sets env vars (only if not already set).
@@ -0,0 +1,104 @@
+// Migrate applies, rolls back or lists the embedded schema migrations.
+// Run from project root: go run ./cmd/migrate [up | down [N] | status]
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"million-rps/internal/database"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run executes the command named in os.Args and returns the process exit code, so deferred cleanup runs
+// before main exits.
+func run() int {
+	loadEnvFile(".env")
+
+	ctx := context.Background()
+	if database.DB(ctx) == nil {
+		fmt.Fprintln(os.Stderr, "DATABASE_URL not set or DB connection failed")
+		return 1
+	}
+	defer database.Close()
+
+	cmd := "up"
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+	}
+	var err error
+	switch cmd {
+	case "up":
+		err = database.Migrate(ctx)
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			if steps, err = strconv.Atoi(os.Args[2]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, "down: N must be a positive integer")
+				return 2
+			}
+		}
+		err = database.Rollback(ctx, steps)
+	case "status":
+		err = printStatus(ctx)
+	default:
+		fmt.Fprintln(os.Stderr, "usage: migrate [up | down [N] | status]")
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, cmd+" failed:", err)
+		return 1
+	}
+	return 0
+}
+
+func printStatus(ctx context.Context) error {
+	list, err := database.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range list {
+		applied := "pending"
+		if m.AppliedAt != nil {
+			applied = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Printf("%04d  %-30s %s\n", m.Version, m.Name, applied)
+	}
+	return nil
+}
+
+func loadEnvFile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:idx])
+		val := strings.TrimSpace(line[idx+1:])
+		if strings.HasPrefix(val, `"`) && strings.HasSuffix(val, `"`) {
+			val = strings.Trim(val, `"`)
+		} else if strings.HasPrefix(val, "'") && strings.HasSuffix(val, "'") {
+			val = strings.Trim(val, "'")
+		}
+		if key != "" && os.Getenv(key) == "" {
+			_ = os.Setenv(key, val)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/internal/models"
+	"million-rps/pkg/logger"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const commandStatusPrefix = "command:"
+
+// CommandKey returns the Redis key holding a command's outcome ("command:<id>").
+func CommandKey(id string) string {
+	return commandStatusPrefix + id
+}
+
+// SetCommandPending records a freshly accepted command as pending. Called before the 202 is sent, so a
+// client polling the returned Location never sees 404 for an accepted command. Uses SETNX so it never
+// overwrites an outcome the worker already recorded.
+func SetCommandPending(ctx context.Context, cmd *models.TodoCommand) {
+	c := Client(ctx)
+	if c == nil {
+		return
+	}
+	b, err := json.Marshal(statusFor(cmd, models.CommandPending, ""))
+	if err != nil {
+		return
+	}
+	if err := c.SetNX(ctx, CommandKey(cmd.CommandID), b, commandTTL()).Err(); err != nil {
+		logger.Error(ctx, "Cache set command pending failed", "error", err, "command_id", cmd.CommandID)
+	}
+}
+
+// SetCommandOutcome records the final outcome (applied or failed with reason) of a command.
+func SetCommandOutcome(ctx context.Context, cmd *models.TodoCommand, status, reason string) {
+	if cmd.CommandID == "" {
+		return
+	}
+	c := Client(ctx)
+	if c == nil {
+		return
+	}
+	b, err := json.Marshal(statusFor(cmd, status, reason))
+	if err != nil {
+		return
+	}
+	if err := c.Set(ctx, CommandKey(cmd.CommandID), b, commandTTL()).Err(); err != nil {
+		logger.Error(ctx, "Cache set command outcome failed", "error", err, "command_id", cmd.CommandID)
+	}
+}
+
+// GetCommandStatus returns the recorded outcome for a command id. Returns (nil, false) when unknown or expired.
+func GetCommandStatus(ctx context.Context, id string) (*models.CommandStatus, bool) {
+	c := Client(ctx)
+	if c == nil {
+		return nil, false
+	}
+	b, err := c.Get(ctx, CommandKey(id)).Bytes()
+	if err == redis.Nil || err != nil {
+		return nil, false
+	}
+	var st models.CommandStatus
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, false
+	}
+	return &st, true
+}
+
+func statusFor(cmd *models.TodoCommand, status, reason string) *models.CommandStatus {
+	return &models.CommandStatus{
+		CommandID: cmd.CommandID,
+		Action:    cmd.Action,
+		TodoID:    cmd.ID,
+		UserID:    cmd.UserID,
+		Status:    status,
+		Reason:    reason,
+		UpdatedAt: time.Now(),
+	}
+}
+
+func commandTTL() time.Duration {
+	return time.Duration(config.Get().CommandTTL) * time.Second
+}
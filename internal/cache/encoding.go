@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings stored next to every cached list, under "<key>:<encoding>".
+const (
+	Identity = ""
+	Gzip     = "gzip"
+	Zstd     = "zstd"
+)
+
+// Encodings lists the pre-compressed variants in order of preference (smallest first).
+var Encodings = []string{Zstd, Gzip}
+
+// zstdEncoder is shared; EncodeAll is safe for concurrent use.
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+
+// variantKey returns the key holding key's body in enc.
+func variantKey(key, enc string) string {
+	if enc == Identity {
+		return key
+	}
+	return key + ":" + enc
+}
+
+// withVariants returns key followed by the key of every encoded variant.
+func withVariants(key string) []string {
+	keys := []string{key}
+	for _, enc := range Encodings {
+		keys = append(keys, variantKey(key, enc))
+	}
+	return keys
+}
+
+// variantETag derives the ETag of an encoded variant; representations in different encodings must not
+// share a strong validator.
+func variantETag(etag, enc string) string {
+	if enc == Identity {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + enc + `"`
+}
+
+// encodeList returns the framed values to store for list body b, keyed by variantKey(key, enc): the identity
+// body and each compressed variant, each with its own ETag. Compression is paid here, once per cache fill.
+func encodeList(key string, b []byte) map[string][]byte {
+	etag := ETag(b)
+	out := map[string][]byte{key: frameWith(etag, b)}
+	for _, enc := range Encodings {
+		if z, err := compress(enc, b); err == nil {
+			out[variantKey(key, enc)] = frameWith(variantETag(etag, enc), z)
+		}
+	}
+	return out
+}
+
+func compress(enc string, b []byte) ([]byte, error) {
+	switch enc {
+	case Zstd:
+		return zstdEncoder.EncodeAll(b, make([]byte, 0, len(b)/4)), nil
+	default:
+		var buf bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Entry is a cached list response: the JSON body in Encoding (Identity for plain JSON) and its ETag.
+type Entry struct {
+	Body     []byte
+	ETag     string
+	Encoding string
+}
+
+// ETag returns a strong HTTP validator for b: a quoted prefix of its SHA-256, identical on every replica.
+func ETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// frameWith stores etag in front of b, separated by a newline, so one GET returns both. JSON bodies start
+// with '[' or '{', never '"', which keeps framed and unframed values apart.
+func frameWith(etag string, b []byte) []byte {
+	out := make([]byte, 0, len(etag)+1+len(b))
+	out = append(out, etag...)
+	out = append(out, '\n')
+	return append(out, b...)
+}
+
+// unframe splits a value written by frame. Values cached before ETags were stored get theirs computed.
+func unframe(raw []byte) Entry {
+	if len(raw) > 0 && raw[0] == '"' {
+		if i := bytes.IndexByte(raw, '\n'); i > 0 {
+			return Entry{Body: raw[i+1:], ETag: string(raw[:i])}
+		}
+	}
+	return Entry{Body: raw, ETag: ETag(raw)}
+}
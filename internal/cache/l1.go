@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/internal/metrics"
+	"million-rps/pkg/logger"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidationChannel carries invalidated key prefixes (newline-separated) from the worker that dropped them
+// in Redis to every replica's L1.
+const invalidationChannel = "cache:invalidate"
+
+// subscribeRetry is the pause before resubscribing after the invalidation subscription dropped.
+const subscribeRetry = time.Second
+
+// l1Shards splits the L1 so concurrent readers and fills of different keys rarely share a lock.
+const l1Shards = 16
+
+// The L1 is an in-process copy of recently read Redis values, by Redis key, kept for CACHE_L1_TTL_MS and
+// capped at CACHE_L1_MAX_MB (oldest fill evicted first). It only serves while SubscribeInvalidations is
+// connected, so a write applied by any replica's worker evicts the copy here right after Redis drops its own;
+// while the subscription is down every read goes to Redis as before.
+var (
+	l1       [l1Shards]l1Shard
+	l1Active atomic.Bool
+	// l1Gen is bumped by every invalidation; a fill whose Redis read started before the bump is discarded,
+	// so a value read just before a write cannot outlive the write's invalidation here.
+	l1Gen atomic.Uint64
+)
+
+type l1Item struct {
+	raw     []byte
+	expires int64  // unix nanoseconds
+	seq     uint64 // fill order within the shard
+}
+
+// l1Fill records one fill in a shard's eviction order.
+type l1Fill struct {
+	key string
+	seq uint64
+}
+
+type l1Shard struct {
+	mu    sync.RWMutex
+	items map[string]l1Item
+	order []l1Fill // oldest fill first; fills whose key was since dropped or refilled no longer match items
+	seq   uint64
+	size  int
+}
+
+func l1ShardFor(key string) *l1Shard {
+	h := uint32(2166136261) // FNV-1a, inlined to keep the hot path allocation-free
+	for i := 0; i < len(key); i++ {
+		h = (h ^ uint32(key[i])) * 16777619
+	}
+	return &l1[h%l1Shards]
+}
+
+// l1Get returns the L1 copy of key while it is fresh and the L1 is serving.
+func l1Get(key string) ([]byte, bool) {
+	if !l1Active.Load() {
+		return nil, false
+	}
+	s := l1ShardFor(key)
+	s.mu.RLock()
+	it, ok := s.items[key]
+	s.mu.RUnlock()
+	ok = ok && time.Now().UnixNano() < it.expires
+	metrics.CacheL1Lookup(ok)
+	if !ok {
+		return nil, false
+	}
+	return it.raw, true
+}
+
+// l1Set stores raw under key unless an invalidation happened since gen was read (before the Redis read that
+// produced raw). Values too large for a shard are not kept.
+func l1Set(key string, raw []byte, gen uint64) {
+	cfg := config.Get()
+	limit := (cfg.CacheL1MaxMB << 20) / l1Shards
+	n := len(key) + len(raw)
+	if !l1Active.Load() || cfg.CacheL1TTL <= 0 || n > limit {
+		return
+	}
+	s := l1ShardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l1Gen.Load() != gen {
+		return
+	}
+	if s.items == nil {
+		s.items = make(map[string]l1Item)
+	}
+	if old, ok := s.items[key]; ok {
+		s.size -= len(key) + len(old.raw)
+	}
+	s.seq++
+	s.items[key] = l1Item{raw: raw, expires: time.Now().Add(time.Duration(cfg.CacheL1TTL) * time.Millisecond).UnixNano(), seq: s.seq}
+	s.size += n
+	s.order = append(s.order, l1Fill{key: key, seq: s.seq})
+	for s.size > limit && len(s.order) > 0 {
+		f := s.order[0]
+		s.order = s.order[1:]
+		if old, ok := s.items[f.key]; ok && old.seq == f.seq {
+			delete(s.items, f.key)
+			s.size -= len(f.key) + len(old.raw)
+		}
+	}
+	// Refills and drops leave stale fills behind in order; rebuild it before it dwarfs the map.
+	if len(s.order) > 2*len(s.items)+64 {
+		s.order = s.order[:0:0]
+		for k, it := range s.items {
+			s.order = append(s.order, l1Fill{key: k, seq: it.seq})
+		}
+		slices.SortFunc(s.order, func(a, b l1Fill) int { return cmp.Compare(a.seq, b.seq) })
+	}
+}
+
+// l1Drop removes every L1 entry whose key starts with one of prefixes, and discards fills in flight.
+func l1Drop(prefixes []string) {
+	l1Gen.Add(1)
+	for i := range l1 {
+		s := &l1[i]
+		s.mu.Lock()
+		for k, it := range s.items {
+			for _, p := range prefixes {
+				if p != "" && strings.HasPrefix(k, p) {
+					delete(s.items, k)
+					s.size -= len(k) + len(it.raw)
+					break
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// l1Clear empties the L1 and discards fills in flight.
+func l1Clear() {
+	l1Gen.Add(1)
+	for i := range l1 {
+		s := &l1[i]
+		s.mu.Lock()
+		s.items, s.order, s.size = nil, nil, 0
+		s.mu.Unlock()
+	}
+}
+
+// publishInvalidation drops key prefixes from this process's L1 and tells every other replica to do the same.
+// Called after the keys were deleted in Redis, so a replica refilling from Redis gets the new state.
+func publishInvalidation(ctx context.Context, c *redis.Client, prefixes ...string) {
+	l1Drop(prefixes)
+	if err := c.Publish(ctx, invalidationChannel, strings.Join(prefixes, "\n")).Err(); err != nil {
+		logger.Error(ctx, "Cache invalidation publish failed", "error", err)
+	}
+}
+
+// SubscribeInvalidations applies invalidations published by every replica's worker to the L1 until ctx ends.
+// The L1 serves only while the subscription is up: it starts empty once Redis confirms the subscription and
+// is cleared and switched off when the connection drops, since invalidations sent meanwhile are lost.
+// Does nothing when CACHE_L1_TTL_MS is 0.
+func SubscribeInvalidations(ctx context.Context) {
+	if config.Get().CacheL1TTL <= 0 {
+		return
+	}
+	for {
+		c := Wait(ctx)
+		if c == nil {
+			return
+		}
+		subscribe(ctx, c)
+		t := time.NewTimer(subscribeRetry)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// subscribe serves the L1 for the lifetime of one subscription connection.
+func subscribe(ctx context.Context, c *redis.Client) {
+	ps := c.Subscribe(ctx, invalidationChannel)
+	// Receive does not watch ctx; closing the subscription unblocks it.
+	stop := context.AfterFunc(ctx, func() { _ = ps.Close() })
+	defer func() {
+		stop()
+		l1Active.Store(false)
+		l1Clear()
+		_ = ps.Close()
+	}()
+	for {
+		msg, err := ps.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error(ctx, "Cache invalidation subscription lost; L1 off until it reconnects", "error", err)
+			}
+			return
+		}
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				l1Clear()
+				l1Active.Store(true)
+			}
+		case *redis.Message:
+			l1Drop(strings.Split(m.Payload, "\n"))
+		}
+	}
+}
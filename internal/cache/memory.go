@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"million-rps/internal/models"
+)
+
+// Memory is an in-memory TodoCache using the same keys, generations and invalidation rules as Redis (no TTLs).
+// "Async" setters complete before returning. Safe for concurrent use.
+type Memory struct {
+	mu      sync.RWMutex
+	entries map[string][]byte
+	indexes map[string]map[string]struct{}
+	gens    map[string]Gen
+	cmds    map[string]models.CommandStatus
+}
+
+var _ TodoCache = (*Memory)(nil)
+
+// NewMemory returns an empty in-memory cache.
+func NewMemory() *Memory {
+	return &Memory{
+		entries: make(map[string][]byte),
+		indexes: make(map[string]map[string]struct{}),
+		gens:    make(map[string]Gen),
+		cmds:    make(map[string]models.CommandStatus),
+	}
+}
+
+// Len returns the number of cached entries (command statuses excluded).
+func (m *Memory) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.entries)
+}
+
+func (m *Memory) get(key string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.entries[key]
+	return b, ok
+}
+
+// getEntry returns a list response stored by setList, in enc if that variant exists.
+func (m *Memory) getEntry(key, enc string) (Entry, bool) {
+	if enc != Identity {
+		if b, ok := m.get(variantKey(key, enc)); ok {
+			e := unframe(b)
+			e.Encoding = enc
+			return e, true
+		}
+	}
+	b, ok := m.get(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return unframe(b), true
+}
+
+func (m *Memory) gen(genKey string) Gen {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.gens[genKey]
+}
+
+func (m *Memory) set(gen Gen, genKey, key string, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gens[genKey] != gen {
+		return
+	}
+	m.entries[key] = b
+}
+
+// setList stores list body b and its compressed variants like Redis does, tracking every key in index if set.
+// Like fillScript, it does nothing once genKey moved past gen.
+func (m *Memory) setList(gen Gen, genKey, index, key string, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	values := encodeList(key, b)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gens[genKey] != gen {
+		return
+	}
+	if index != "" && m.indexes[index] == nil {
+		m.indexes[index] = make(map[string]struct{})
+	}
+	for k, v := range values {
+		m.entries[k] = v
+		if index != "" {
+			m.indexes[index][k] = struct{}{}
+		}
+	}
+}
+
+func (m *Memory) invalidateIndex(genKey, index string, extra ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gens[genKey]++
+	for key := range m.indexes[index] {
+		delete(m.entries, key)
+	}
+	delete(m.indexes, index)
+	for _, key := range extra {
+		delete(m.entries, key)
+	}
+}
+
+func (m *Memory) TodosGen(ctx context.Context) Gen { return m.gen(todosGenKey) }
+func (m *Memory) UserTodosGen(ctx context.Context, userID string) Gen {
+	return m.gen(userTodosGenKey(userID))
+}
+func (m *Memory) TodoGen(ctx context.Context, id string) Gen { return m.gen(todoGenKey(id)) }
+
+func (m *Memory) GetRawTodos(ctx context.Context, enc string) (Entry, bool) {
+	return m.getEntry(todosCacheKey, enc)
+}
+func (m *Memory) SetRawTodosAsync(gen Gen, b []byte) {
+	m.setList(gen, todosGenKey, "", todosCacheKey, b)
+}
+
+func (m *Memory) GetRawTodosLimit(ctx context.Context, limit int, enc string) (Entry, bool) {
+	return m.getEntry(todosLimitPrefix+strconv.Itoa(limit), enc)
+}
+func (m *Memory) SetRawTodosLimitAsync(gen Gen, limit int, b []byte) {
+	m.setList(gen, todosGenKey, todosLimitIndex, todosLimitPrefix+strconv.Itoa(limit), b)
+}
+
+func (m *Memory) GetRawTodosFirstPage(ctx context.Context, limit int, enc string) (Entry, bool) {
+	return m.getEntry(todosPagePrefix+strconv.Itoa(limit), enc)
+}
+func (m *Memory) SetRawTodosFirstPageAsync(gen Gen, limit int, b []byte) {
+	m.setList(gen, todosGenKey, todosLimitIndex, todosPagePrefix+strconv.Itoa(limit), b)
+}
+
+func (m *Memory) GetRawUserTodos(ctx context.Context, userID string, limit int, enc string) (Entry, bool) {
+	return m.getEntry(userTodosKey(userID, limit), enc)
+}
+func (m *Memory) SetRawUserTodosAsync(gen Gen, userID string, limit int, b []byte) {
+	m.setList(gen, userTodosGenKey(userID), userTodosIndex(userID), userTodosKey(userID, limit), b)
+}
+
+func (m *Memory) GetRawTodo(ctx context.Context, id string) ([]byte, bool) {
+	return m.get(CacheKey(id))
+}
+func (m *Memory) SetRawTodoAsync(gen Gen, id string, b []byte) {
+	m.set(gen, todoGenKey(id), CacheKey(id), b)
+}
+
+func (m *Memory) InvalidateTodos(ctx context.Context) {
+	m.invalidateIndex(todosGenKey, todosLimitIndex, withVariants(todosCacheKey)...)
+}
+
+func (m *Memory) InvalidateUserTodos(ctx context.Context, userID string) {
+	m.invalidateIndex(userTodosGenKey(userID), userTodosIndex(userID))
+}
+
+func (m *Memory) InvalidateTodo(ctx context.Context, id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gens[todoGenKey(id)]++
+	delete(m.entries, CacheKey(id))
+}
+
+func (m *Memory) InvalidateBatch(ctx context.Context, todoIDs, userIDs []string) {
+	m.InvalidateTodos(ctx)
+	for _, u := range userIDs {
+		m.InvalidateUserTodos(ctx, u)
+	}
+	for _, id := range todoIDs {
+		m.InvalidateTodo(ctx, id)
+	}
+}
+
+func (m *Memory) SetCommandPending(ctx context.Context, cmd *models.TodoCommand) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.cmds[cmd.CommandID]; !ok {
+		m.cmds[cmd.CommandID] = *statusFor(cmd, models.CommandPending, "")
+	}
+}
+
+func (m *Memory) SetCommandOutcome(ctx context.Context, cmd *models.TodoCommand, status, reason string) {
+	if cmd.CommandID == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cmds[cmd.CommandID] = *statusFor(cmd, status, reason)
+}
+
+func (m *Memory) GetCommandStatus(ctx context.Context, id string) (*models.CommandStatus, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	st, ok := m.cmds[id]
+	if !ok {
+		return nil, false
+	}
+	return &st, true
+}
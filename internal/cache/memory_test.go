@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"million-rps/internal/models"
+)
+
+func TestInvalidateTodosDropsEveryListKey(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.SetRawTodosAsync(0, []byte("all"))
+	m.SetRawTodosLimitAsync(0, 10, []byte("ten"))
+	m.SetRawTodosLimitAsync(0, 1000, []byte("thousand"))
+	m.SetRawTodosFirstPageAsync(0, 100, []byte("page"))
+	m.SetRawTodoAsync(0, "a", []byte("item"))
+
+	m.InvalidateTodos(ctx)
+
+	if _, ok := m.GetRawTodos(ctx, Identity); ok {
+		t.Error("todos:all survived invalidation")
+	}
+	for _, limit := range []int{10, 1000} {
+		if _, ok := m.GetRawTodosLimit(ctx, limit, Identity); ok {
+			t.Errorf("todos:limit:%d survived invalidation", limit)
+		}
+	}
+	if _, ok := m.GetRawTodosFirstPage(ctx, 100, Identity); ok {
+		t.Error("todos:page:100 survived invalidation")
+	}
+	if _, ok := m.GetRawTodo(ctx, "a"); !ok {
+		t.Error("per-item key should only be dropped by InvalidateTodo")
+	}
+}
+
+func TestInvalidateUserTodosIsScopedToUser(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.SetRawUserTodosAsync(0, "alice", 0, []byte("a0"))
+	m.SetRawUserTodosAsync(0, "alice", 5, []byte("a5"))
+	m.SetRawUserTodosAsync(0, "bob", 0, []byte("b0"))
+
+	m.InvalidateUserTodos(ctx, "alice")
+
+	if _, ok := m.GetRawUserTodos(ctx, "alice", 0, Identity); ok {
+		t.Error("alice limit 0 survived invalidation")
+	}
+	if _, ok := m.GetRawUserTodos(ctx, "alice", 5, Identity); ok {
+		t.Error("alice limit 5 survived invalidation")
+	}
+	if _, ok := m.GetRawUserTodos(ctx, "bob", 0, Identity); !ok {
+		t.Error("bob's list was invalidated by alice's write")
+	}
+}
+
+func TestPendingNeverOverwritesOutcome(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	cmd := &models.TodoCommand{CommandID: "c1", Action: "create", ID: "t1", UserID: "u"}
+	m.SetCommandOutcome(ctx, cmd, models.CommandApplied, "")
+	m.SetCommandPending(ctx, cmd)
+
+	st, ok := m.GetCommandStatus(ctx, "c1")
+	if !ok || st.Status != models.CommandApplied {
+		t.Fatalf("status = %+v, want applied", st)
+	}
+}
+
+func TestInvalidateBatch(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.SetRawTodosLimitAsync(0, 10, []byte("ten"))
+	m.SetRawUserTodosAsync(0, "alice", 0, []byte("a0"))
+	m.SetRawUserTodosAsync(0, "bob", 0, []byte("b0"))
+	m.SetRawTodoAsync(0, "a", []byte("item a"))
+	m.SetRawTodoAsync(0, "b", []byte("item b"))
+
+	m.InvalidateBatch(ctx, []string{"a"}, []string{"alice"})
+
+	if _, ok := m.GetRawTodosLimit(ctx, 10, Identity); ok {
+		t.Error("todos:limit:10 survived batch invalidation")
+	}
+	if _, ok := m.GetRawUserTodos(ctx, "alice", 0, Identity); ok {
+		t.Error("alice's list survived batch invalidation")
+	}
+	if _, ok := m.GetRawTodo(ctx, "a"); ok {
+		t.Error("todo:a survived batch invalidation")
+	}
+	if _, ok := m.GetRawUserTodos(ctx, "bob", 0, Identity); !ok {
+		t.Error("bob's list was invalidated")
+	}
+	if _, ok := m.GetRawTodo(ctx, "b"); !ok {
+		t.Error("todo:b was invalidated")
+	}
+}
+
+func TestListEntriesCarryTheirETag(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	body := []byte(`[{"id":"a"}]`)
+	m.SetRawTodosLimitAsync(0, 10, body)
+	e, ok := m.GetRawTodosLimit(ctx, 10, Identity)
+	if !ok || string(e.Body) != string(body) || e.ETag != ETag(body) {
+		t.Fatalf("entry = %q / %s, want body %s with ETag %s", e.Body, e.ETag, body, ETag(body))
+	}
+	// Values cached before ETags were stored are still served, with the ETag computed.
+	if legacy := unframe(body); string(legacy.Body) != string(body) || legacy.ETag != ETag(body) {
+		t.Fatalf("unframe(legacy) = %q / %s", legacy.Body, legacy.ETag)
+	}
+}
+
+func TestListVariantsAreWrittenAndDroppedTogether(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	m.SetRawTodosAsync(0, []byte(`[{"id":"a"}]`))
+	m.SetRawUserTodosAsync(0, "alice", 0, []byte(`[{"id":"a"}]`))
+	for _, enc := range Encodings {
+		e, ok := m.GetRawTodos(ctx, enc)
+		if !ok || e.Encoding != enc || e.ETag == ETag([]byte(`[{"id":"a"}]`)) {
+			t.Fatalf("%s variant = %+v, %v", enc, e, ok)
+		}
+	}
+	m.InvalidateBatch(ctx, nil, []string{"alice"})
+	if m.Len() != 0 {
+		t.Fatalf("%d entries left after invalidation, want 0", m.Len())
+	}
+}
@@ -2,146 +2,412 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
-	"sync"
 	"time"
 
 	"million-rps/internal/config"
-	"million-rps/internal/models"
+	"million-rps/internal/lazyconn"
+	"million-rps/internal/metrics"
 	"million-rps/pkg/logger"
 
 	"github.com/redis/go-redis/v9"
 )
 
 const (
-	todosCacheKey = "todos:all"
+	todosCacheKey    = "todos:all"
 	todosLimitPrefix = "todos:limit:"
+	todosPagePrefix  = "todos:page:"
+	// todosLimitIndex is a Redis set holding every todos:limit:N (and todos:page:N) key written, so writes can drop them all.
+	todosLimitIndex = "todos:limit:keys"
+	userTodosPrefix = "todos:user:"
+	// todosGenKey is the generation shared by todos:all, todos:limit:N and todos:page:N.
+	todosGenKey = "todos:gen"
 )
 
-var (
-	client *redis.Client
-	once   sync.Once
-)
+// listPrefixes cover every shared list key (todos:all, todos:limit:N, todos:page:N and their variants) in
+// L1 invalidations.
+var listPrefixes = []string{todosCacheKey, todosLimitPrefix, todosPagePrefix}
+
+// invalidateScript drops cached values atomically. KEYS holds ARGV[1] index sets, then ARGV[2] generation
+// keys, then plain keys: every key tracked in the index sets is deleted along with the sets and the plain
+// keys, and each generation is incremented (and kept for ARGV[3] ms) so fills loaded before now are refused.
+var invalidateScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+local m = tonumber(ARGV[2])
+local del = {}
+for k = 1, n do
+	for _, key in ipairs(redis.call('SMEMBERS', KEYS[k])) do
+		del[#del + 1] = key
+	end
+	del[#del + 1] = KEYS[k]
+end
+for k = n + 1, n + m do
+	redis.call('INCR', KEYS[k])
+	redis.call('PEXPIRE', KEYS[k], ARGV[3])
+end
+for k = n + m + 1, #KEYS do
+	del[#del + 1] = KEYS[k]
+end
+for i = 1, #del, 500 do
+	redis.call('DEL', unpack(del, i, math.min(i + 499, #del)))
+end
+return #del
+`)
+
+// fillScript writes cached values only while the generation at KEYS[1] still equals ARGV[1] (0 when unset),
+// so a value loaded from the database before an invalidation is never written back after it. With ARGV[3]
+// = 1, KEYS[2] is an index set that records every written key. The remaining KEYS get ARGV[4...] in order,
+// each with a TTL of ARGV[2] ms, which the index shares.
+var fillScript = redis.NewScript(`
+if (redis.call('GET', KEYS[1]) or '0') ~= ARGV[1] then
+	return 0
+end
+local first = 2 + tonumber(ARGV[3])
+for i = first, #KEYS do
+	redis.call('SET', KEYS[i], ARGV[i - first + 4], 'PX', ARGV[2])
+	if first == 3 then
+		redis.call('SADD', KEYS[2], KEYS[i])
+	end
+end
+if first == 3 then
+	redis.call('PEXPIRE', KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+// Gen is a cache generation. Read it (TodosGen, UserTodosGen, TodoGen) before loading a value from the
+// database and pass it to the matching fill: the fill is dropped if the value was invalidated in between,
+// so a slow reader cannot put a pre-write list back after the worker applied the write.
+type Gen int64
+
+// noGen never matches a stored generation; fills carrying it are skipped.
+const noGen Gen = -1
+
+// minGenTTL keeps generation keys alive well past any database load, even with a short CACHE_TTL_SEC.
+const minGenTTL = time.Minute
+
+// ErrUnavailable is returned by Ping while no client could be connected yet.
+var ErrUnavailable = errors.New("redis unavailable")
 
-// Client returns the global Redis client (initialized on first use).
+var conn = newConn()
+
+func newConn() *lazyconn.Conn[redis.Client] {
+	return lazyconn.New("Redis", connect, (*redis.Client).Close)
+}
+
+// Client returns the global Redis client, connecting on first use. While Redis is unreachable it returns nil
+// (callers fall back to the database) and retries with backoff (see lazyconn); once connected, the client
+// reconnects on its own.
 func Client(ctx context.Context) *redis.Client {
-	once.Do(func() {
-		cfg := config.Get()
-		opts, err := redis.ParseURL(cfg.RedisURL)
-		if err != nil {
-			logger.Error(ctx, "Invalid REDIS_URL", "error", err, "url", cfg.RedisURL)
-			return
-		}
-		opts.PoolSize = cfg.RedisPoolSize
-		opts.MinIdleConns = opts.PoolSize / 4
-		opts.ReadTimeout = 15 * time.Second
-		opts.WriteTimeout = 10 * time.Second
-		opts.PoolTimeout = 10 * time.Second
-		client = redis.NewClient(opts)
-		if err := client.Ping(ctx).Err(); err != nil {
-			logger.Error(ctx, "Redis ping failed", "error", err)
-			return
-		}
-		logger.Info(ctx, "Redis client initialized", "pool_size", cfg.RedisPoolSize)
-	})
-	return client
+	return conn.Get(ctx)
+}
+
+// connect creates a client from REDIS_URL and pings it.
+func connect(ctx context.Context) (*redis.Client, error) {
+	cfg := config.Get()
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	opts.PoolSize = cfg.RedisPoolSize
+	opts.MinIdleConns = opts.PoolSize / 4
+	opts.ReadTimeout = 15 * time.Second
+	opts.WriteTimeout = 10 * time.Second
+	opts.PoolTimeout = 10 * time.Second
+	c := redis.NewClient(opts)
+	if err := c.Ping(ctx).Err(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	logger.Info(ctx, "Redis client initialized", "pool_size", cfg.RedisPoolSize)
+	return c, nil
+}
+
+// Wait blocks until the client is connected or ctx ends (then returns nil). For startup paths that cannot
+// run without Redis.
+func Wait(ctx context.Context) *redis.Client {
+	return conn.Wait(ctx)
+}
+
+// Ping checks that Redis answers now, for readiness probes.
+func Ping(ctx context.Context) error {
+	c := Client(ctx)
+	if c == nil {
+		return ErrUnavailable
+	}
+	return c.Ping(ctx).Err()
+}
+
+// Close closes the Redis client, if one was created, and stops further connection attempts.
+func Close() error {
+	return conn.Close()
 }
 
 // getRaw returns cached bytes for key. Used for zero-copy response path.
 func getRaw(ctx context.Context, key string) ([]byte, bool) {
+	b, ok, l1 := lookup(ctx, key)
+	if !l1 {
+		metrics.CacheLookup(ok)
+	}
+	return b, ok
+}
+
+// lookup returns the value under key from the L1 or else Redis, copying a Redis hit into the L1. l1 reports
+// whether the L1 answered, so callers count only lookups that reached Redis in the Redis metrics.
+func lookup(ctx context.Context, key string) (b []byte, ok, l1 bool) {
+	if b, ok := l1Get(key); ok {
+		return b, true, true
+	}
 	c := Client(ctx)
 	if c == nil {
-		return nil, false
+		return nil, false, false
 	}
+	gen := l1Gen.Load()
 	b, err := c.Get(ctx, key).Bytes()
-	if err == redis.Nil || err != nil {
-		return nil, false
+	if err != nil {
+		return nil, false, false
+	}
+	l1Set(key, b, gen)
+	return b, true, false
+}
+
+// getEntry returns the cached list response under key with its ETag: the enc variant if one is cached,
+// else the plain JSON.
+func getEntry(ctx context.Context, key, enc string) (Entry, bool) {
+	if enc != Identity {
+		// A variant miss is not counted: the plain key lookup below counts the request.
+		if raw, ok, l1 := lookup(ctx, variantKey(key, enc)); ok {
+			if !l1 {
+				metrics.CacheLookup(true)
+			}
+			e := unframe(raw)
+			e.Encoding = enc
+			return e, true
+		}
+	}
+	raw, ok := getRaw(ctx, key)
+	if !ok {
+		return Entry{}, false
+	}
+	return unframe(raw), true
+}
+
+// setRawAsync writes b under key unless genKey moved past gen (see fillScript).
+func setRawAsync(gen Gen, genKey, key string, b []byte) {
+	if len(b) == 0 {
+		return
 	}
-	return b, true
+	fill(gen, genKey, "", map[string][]byte{key: b})
 }
 
-func setRawAsync(key string, b []byte) {
+// setListAsync writes list body b under key together with its pre-compressed variants (see encodeList),
+// all framed with their ETags, unless genKey moved past gen. With indexKey set, every written key is recorded
+// there so invalidation can find it; the index gets the same TTL, refreshed on every add, so it always
+// outlives the keys it tracks.
+func setListAsync(gen Gen, genKey, indexKey, key string, b []byte) {
 	if len(b) == 0 {
 		return
 	}
+	fill(gen, genKey, indexKey, encodeList(key, b))
+}
+
+// fill runs fillScript for values in the background path's own timeout.
+func fill(gen Gen, genKey, indexKey string, values map[string][]byte) {
+	if gen == noGen {
+		return
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	c := Client(ctx)
 	if c == nil {
 		return
 	}
-	cfg := config.Get()
-	ttl := time.Duration(cfg.CacheTTL) * time.Second
-	_ = c.Set(ctx, key, b, ttl).Err()
+	ttl := time.Duration(config.Get().CacheTTL) * time.Second
+	keys := []string{genKey}
+	hasIndex := 0
+	if indexKey != "" {
+		keys, hasIndex = append(keys, indexKey), 1
+	}
+	args := []any{int64(gen), ttl.Milliseconds(), hasIndex}
+	for k, v := range values {
+		keys = append(keys, k)
+		args = append(args, v)
+	}
+	if err := fillScript.Run(ctx, c, keys, args...).Err(); err != nil {
+		logger.Error(ctx, "Cache fill failed", "error", err, "key", keys[len(keys)-1])
+	}
+}
+
+// readGen returns the generation stored at key (0 if unset), or noGen when Redis cannot say.
+func readGen(ctx context.Context, key string) Gen {
+	c := Client(ctx)
+	if c == nil {
+		return noGen
+	}
+	n, err := c.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0
+	}
+	if err != nil {
+		return noGen
+	}
+	return Gen(n)
+}
+
+// TodosGen returns the generation to pass to the todos:all, todos:limit:N and todos:page:N fills.
+func TodosGen(ctx context.Context) Gen { return readGen(ctx, todosGenKey) }
+
+// UserTodosGen returns the generation to pass to SetRawUserTodosAsync for userID.
+func UserTodosGen(ctx context.Context, userID string) Gen {
+	return readGen(ctx, userTodosGenKey(userID))
 }
 
-// GetRawTodos returns the cached full list as raw JSON bytes (no unmarshal). Use on the hot path for max throughput.
-func GetRawTodos(ctx context.Context) ([]byte, bool) {
-	return getRaw(ctx, todosCacheKey)
+// TodoGen returns the generation to pass to SetRawTodoAsync for id.
+func TodoGen(ctx context.Context, id string) Gen { return readGen(ctx, todoGenKey(id)) }
+
+// GetRawTodos returns the cached full list as raw bytes (no unmarshal or compression) with its ETag, in
+// encoding enc if that variant is cached and as plain JSON otherwise. Use on the hot path for max throughput.
+func GetRawTodos(ctx context.Context, enc string) (Entry, bool) {
+	return getEntry(ctx, todosCacheKey, enc)
 }
 
-// SetRawTodosAsync writes raw JSON bytes for full list to Redis in the background.
-func SetRawTodosAsync(b []byte) {
-	setRawAsync(todosCacheKey, b)
+// SetRawTodosAsync writes raw JSON bytes for full list, with their compressed variants, to Redis in the background.
+func SetRawTodosAsync(gen Gen, b []byte) {
+	setListAsync(gen, todosGenKey, "", todosCacheKey, b)
 }
 
-// GetRawTodosLimit returns cached raw JSON for first `limit` todos. Key is "todos:limit:N".
-func GetRawTodosLimit(ctx context.Context, limit int) ([]byte, bool) {
-	return getRaw(ctx, todosLimitPrefix+strconv.Itoa(limit))
+// GetRawTodosLimit returns cached raw JSON for first `limit` todos, like GetRawTodos. Key is "todos:limit:N".
+func GetRawTodosLimit(ctx context.Context, limit int, enc string) (Entry, bool) {
+	return getEntry(ctx, todosLimitPrefix+strconv.Itoa(limit), enc)
 }
 
-// SetRawTodosLimitAsync caches raw JSON for first `limit` todos in the background.
-func SetRawTodosLimitAsync(limit int, b []byte) {
-	setRawAsync(todosLimitPrefix+strconv.Itoa(limit), b)
+// SetRawTodosLimitAsync caches raw JSON for first `limit` todos in the background and tracks the key for invalidation.
+func SetRawTodosLimitAsync(gen Gen, limit int, b []byte) {
+	setListAsync(gen, todosGenKey, todosLimitIndex, todosLimitPrefix+strconv.Itoa(limit), b)
 }
 
-// GetTodos reads the todos list from Redis. Returns (nil, false) on miss or error.
-func GetTodos(ctx context.Context) ([]models.Todo, bool) {
-	b, ok := GetRawTodos(ctx)
-	if !ok {
-		return nil, false
-	}
-	var todos []models.Todo
-	if err := json.Unmarshal(b, &todos); err != nil {
-		return nil, false
-	}
-	return todos, true
+// GetRawTodosFirstPage returns the cached cursor-paginated first page of `limit` todos. Key is "todos:page:N".
+func GetRawTodosFirstPage(ctx context.Context, limit int, enc string) (Entry, bool) {
+	return getEntry(ctx, todosPagePrefix+strconv.Itoa(limit), enc)
 }
 
-// SetTodos writes the todos list to Redis with configured TTL.
-func SetTodos(ctx context.Context, todos []models.Todo) {
+// SetRawTodosFirstPageAsync caches the first page in the background; invalidated together with todos:limit:N.
+func SetRawTodosFirstPageAsync(gen Gen, limit int, b []byte) {
+	setListAsync(gen, todosGenKey, todosLimitIndex, todosPagePrefix+strconv.Itoa(limit), b)
+}
+
+// userTodosKey returns the cache key for a user's list ("todos:user:<id>:limit:N"; N=0 is the full list).
+func userTodosKey(userID string, limit int) string {
+	return userTodosPrefix + userID + ":limit:" + strconv.Itoa(limit)
+}
+
+// userTodosIndex returns the set tracking every cached list key for one user.
+func userTodosIndex(userID string) string {
+	return userTodosPrefix + userID + ":keys"
+}
+
+// userTodosGenKey returns the generation of one user's cached lists.
+func userTodosGenKey(userID string) string {
+	return userTodosPrefix + userID + ":gen"
+}
+
+// GetRawUserTodos returns the cached raw JSON for a user's first `limit` todos (limit 0 = all).
+func GetRawUserTodos(ctx context.Context, userID string, limit int, enc string) (Entry, bool) {
+	return getEntry(ctx, userTodosKey(userID, limit), enc)
+}
+
+// SetRawUserTodosAsync caches raw JSON for a user's list in the background and tracks the key for invalidation.
+func SetRawUserTodosAsync(gen Gen, userID string, limit int, b []byte) {
+	setListAsync(gen, userTodosGenKey(userID), userTodosIndex(userID), userTodosKey(userID, limit), b)
+}
+
+// InvalidateTodos atomically deletes the full-list key and every todos:limit:N key so the next read goes to DB,
+// and bumps their generation so fills already loading the old list are refused.
+func InvalidateTodos(ctx context.Context) {
 	c := Client(ctx)
 	if c == nil {
 		return
 	}
-	b, err := json.Marshal(todos)
-	if err != nil {
-		return
-	}
-	cfg := config.Get()
-	ttl := time.Duration(cfg.CacheTTL) * time.Second
-	_ = c.Set(ctx, todosCacheKey, b, ttl).Err()
+	invalidate(ctx, c, []string{todosLimitIndex}, []string{todosGenKey}, withVariants(todosCacheKey))
+	publishInvalidation(ctx, c, listPrefixes...)
 }
 
-// SetTodosAsync writes the todos list to Redis in the background. Used by code that has []Todo.
-func SetTodosAsync(todos []models.Todo) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	SetTodos(ctx, todos)
+// InvalidateUserTodos atomically deletes every cached list key for one user and bumps their generation.
+func InvalidateUserTodos(ctx context.Context, userID string) {
+	c := Client(ctx)
+	if c == nil || userID == "" {
+		return
+	}
+	invalidate(ctx, c, []string{userTodosIndex(userID)}, []string{userTodosGenKey(userID)}, nil)
+	publishInvalidation(ctx, c, userTodosPrefix+userID+":")
 }
 
-// InvalidateTodos deletes the todos cache key so the next read goes to DB.
-func InvalidateTodos(ctx context.Context) {
+// InvalidateBatch drops, in one script run, everything InvalidateTodos does plus the list keys of every user
+// in userIDs and the item keys of every todo in todoIDs, then publishes one L1 invalidation for all of them.
+// Used by the worker once per applied batch.
+func InvalidateBatch(ctx context.Context, todoIDs, userIDs []string) {
 	c := Client(ctx)
 	if c == nil {
 		return
 	}
-	_ = c.Del(ctx, todosCacheKey).Err()
+	indexes, gens, keys := []string{todosLimitIndex}, []string{todosGenKey}, withVariants(todosCacheKey)
+	prefixes := append([]string(nil), listPrefixes...)
+	for _, u := range userIDs {
+		if u != "" {
+			indexes = append(indexes, userTodosIndex(u))
+			gens = append(gens, userTodosGenKey(u))
+			prefixes = append(prefixes, userTodosPrefix+u+":")
+		}
+	}
+	for _, id := range todoIDs {
+		if id != "" {
+			keys = append(keys, CacheKey(id))
+			gens = append(gens, todoGenKey(id))
+			prefixes = append(prefixes, CacheKey(id))
+		}
+	}
+	invalidate(ctx, c, indexes, gens, keys)
+	publishInvalidation(ctx, c, prefixes...)
+}
+
+// invalidate runs invalidateScript: drops the keys tracked in indexes, the indexes and keys, and bumps gens.
+func invalidate(ctx context.Context, c *redis.Client, indexes, gens, keys []string) {
+	all := make([]string, 0, len(indexes)+len(gens)+len(keys))
+	all = append(append(append(all, indexes...), gens...), keys...)
+	genTTL := max(time.Duration(config.Get().CacheTTL)*time.Second, minGenTTL)
+	if err := invalidateScript.Run(ctx, c, all, len(indexes), len(gens), genTTL.Milliseconds()).Err(); err != nil && err != redis.Nil {
+		logger.Error(ctx, "Cache invalidate failed", "error", err, "indexes", len(indexes), "keys", len(keys))
+	}
 }
 
-// CacheKey returns a stable key for a single todo (optional; we use list key for GetTodos).
+// CacheKey returns a stable key for a single todo ("todo:<id>").
 func CacheKey(id string) string {
 	return fmt.Sprintf("todo:%s", id)
 }
+
+// GetRawTodo returns the cached raw JSON for a single todo.
+func GetRawTodo(ctx context.Context, id string) ([]byte, bool) {
+	return getRaw(ctx, CacheKey(id))
+}
+
+// SetRawTodoAsync caches raw JSON for a single todo in the background.
+func SetRawTodoAsync(gen Gen, id string, b []byte) {
+	setRawAsync(gen, todoGenKey(id), CacheKey(id), b)
+}
+
+// todoGenKey returns the generation of one todo's cached item.
+func todoGenKey(id string) string {
+	return CacheKey(id) + ":gen"
+}
+
+// InvalidateTodo deletes the cached copy of a single todo and bumps its generation.
+func InvalidateTodo(ctx context.Context, id string) {
+	c := Client(ctx)
+	if c == nil || id == "" {
+		return
+	}
+	invalidate(ctx, c, nil, []string{todoGenKey(id)}, []string{CacheKey(id)})
+	publishInvalidation(ctx, c, CacheKey(id))
+}
@@ -0,0 +1,267 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"million-rps/internal/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// useMiniredis points the package client at a fresh in-memory Redis and restores it after the test.
+func useMiniredis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cfg := config.Get()
+	prev := cfg.RedisURL
+	cfg.RedisURL = "redis://" + mr.Addr() + "/0"
+	t.Cleanup(func() {
+		Close()
+		cfg.RedisURL, conn = prev, newConn()
+	})
+	return mr
+}
+
+func TestClientRecoversOnceRedisIsUp(t *testing.T) {
+	ctx := context.Background()
+	mr := useMiniredis(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	if c := Client(ctx); c != nil {
+		t.Fatal("Client connected to a stopped Redis")
+	}
+	if err := Ping(ctx); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Ping while down = %v, want ErrUnavailable", err)
+	}
+	if !time.Now().Before(conn.RetryAt()) {
+		t.Fatal("failed connect did not schedule a retry")
+	}
+
+	if err := mr.StartAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if Wait(waitCtx) == nil {
+		t.Fatal("Wait did not connect after Redis came back")
+	}
+	if err := Ping(ctx); err != nil {
+		t.Fatalf("Ping after recovery = %v", err)
+	}
+}
+
+func TestInvalidateBatchDropsCompressedVariants(t *testing.T) {
+	ctx := context.Background()
+	mr := useMiniredis(t)
+	body := []byte(`[{"id":"a"}]`)
+	SetRawTodosAsync(0, body)
+	SetRawTodosLimitAsync(0, 10, body)
+	SetRawUserTodosAsync(0, "alice", 0, body)
+	if e, ok := GetRawTodosLimit(ctx, 10, Gzip); !ok || e.Encoding != Gzip {
+		t.Fatalf("gzip variant = %+v, %v", e, ok)
+	}
+
+	InvalidateBatch(ctx, nil, []string{"alice"})
+	for _, k := range mr.Keys() {
+		if !strings.HasSuffix(k, ":gen") {
+			t.Errorf("key left after invalidation: %s", k)
+		}
+	}
+}
+
+func TestFillLoadedBeforeInvalidationIsRefused(t *testing.T) {
+	ctx := context.Background()
+	mr := useMiniredis(t)
+	body := []byte(`[{"id":"old"}]`)
+	lists, user, item := TodosGen(ctx), UserTodosGen(ctx, "alice"), TodoGen(ctx, "a")
+
+	// The worker applies a write while the readers are still querying the database.
+	InvalidateBatch(ctx, []string{"a"}, []string{"alice"})
+	SetRawTodosLimitAsync(lists, 10, body)
+	SetRawUserTodosAsync(user, "alice", 0, body)
+	SetRawTodoAsync(item, "a", body)
+	for _, k := range mr.Keys() {
+		if !strings.HasSuffix(k, ":gen") {
+			t.Errorf("stale fill written to %s", k)
+		}
+	}
+
+	// Fills loaded after the write go through.
+	SetRawTodosLimitAsync(TodosGen(ctx), 10, body)
+	SetRawTodoAsync(TodoGen(ctx, "a"), "a", body)
+	if _, ok := GetRawTodosLimit(ctx, 10, Identity); !ok {
+		t.Error("fresh list fill was refused")
+	}
+	if _, ok := GetRawTodo(ctx, "a"); !ok {
+		t.Error("fresh item fill was refused")
+	}
+}
+
+// useL1 subscribes the L1 to invalidations against mr and waits until it serves.
+func useL1(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		SubscribeInvalidations(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for !l1Active.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("L1 did not start serving")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestL1ServesUntilAnotherReplicaInvalidates(t *testing.T) {
+	ctx := context.Background()
+	mr := useMiniredis(t)
+	useL1(t)
+	SetRawTodosLimitAsync(0, 1, []byte(`[{"id":"a"}]`))
+	if e, ok := GetRawTodosLimit(ctx, 1, Identity); !ok || string(e.Body) != `[{"id":"a"}]` {
+		t.Fatalf("first read = %q, %v", e.Body, ok)
+	}
+
+	// Rewrite Redis behind the L1's back: reads keep coming from memory.
+	SetRawTodosLimitAsync(0, 1, []byte(`[{"id":"b"}]`))
+	if e, _ := GetRawTodosLimit(ctx, 1, Identity); string(e.Body) != `[{"id":"a"}]` {
+		t.Fatalf("L1 read = %q, want the copy taken on the first read", e.Body)
+	}
+
+	// Another replica's worker announces the write.
+	mr.Publish(invalidationChannel, todosLimitPrefix)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if e, _ := GetRawTodosLimit(ctx, 1, Identity); string(e.Body) == `[{"id":"b"}]` {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("L1 kept serving the invalidated list")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// redisHits returns the Redis cache hits recorded so far.
+func redisHits(t *testing.T) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "million_rps_cache_lookups_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "result" && l.GetValue() == "hit" {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestL1HitsAreNotCountedAsRedisHits(t *testing.T) {
+	ctx := context.Background()
+	useMiniredis(t)
+	useL1(t)
+	SetRawTodosLimitAsync(0, 1, []byte(`[{"id":"a"}]`))
+	SetRawTodoAsync(0, "a", []byte(`{"id":"a"}`))
+	before := redisHits(t)
+	for range 3 {
+		if _, ok := GetRawTodosLimit(ctx, 1, Gzip); !ok {
+			t.Fatal("cached list missed")
+		}
+		if _, ok := GetRawTodo(ctx, "a"); !ok {
+			t.Fatal("cached todo missed")
+		}
+	}
+	// Only the first read of each key reaches Redis; the rest are L1 hits.
+	if got := redisHits(t) - before; got != 2 {
+		t.Fatalf("Redis hits = %v, want 2", got)
+	}
+}
+
+func TestL1DropsFillsRacingAnInvalidation(t *testing.T) {
+	useMiniredis(t)
+	useL1(t)
+	gen := l1Gen.Load()
+	InvalidateTodo(context.Background(), "a")
+	l1Set(CacheKey("a"), []byte(`{"id":"a"}`), gen)
+	if _, ok := l1Get(CacheKey("a")); ok {
+		t.Fatal("a value read before the invalidation was kept in the L1")
+	}
+}
+
+func TestL1EvictsOldestFillsOverSizeCap(t *testing.T) {
+	useMiniredis(t)
+	useL1(t)
+	cfg := config.Get()
+	prev := cfg.CacheL1MaxMB
+	cfg.CacheL1MaxMB = 1
+	t.Cleanup(func() { cfg.CacheL1MaxMB = prev })
+
+	body := make([]byte, 10<<10)
+	var keys []string
+	for i := 0; i < 200; i++ {
+		k := CacheKey(strconv.Itoa(i))
+		keys = append(keys, k)
+		l1Set(k, body, l1Gen.Load())
+	}
+	total := 0
+	for i := range l1 {
+		total += l1[i].size
+	}
+	if total > 1<<20 {
+		t.Fatalf("L1 holds %d bytes, over the 1 MiB cap", total)
+	}
+	if _, ok := l1Get(keys[len(keys)-1]); !ok {
+		t.Fatal("newest fill was evicted")
+	}
+}
+
+func TestL1EvictsByLatestFillOfARefilledKey(t *testing.T) {
+	useMiniredis(t)
+	useL1(t)
+	cfg := config.Get()
+	prev := cfg.CacheL1MaxMB
+	cfg.CacheL1MaxMB = 1
+	t.Cleanup(func() { cfg.CacheL1MaxMB = prev })
+
+	// Six 10 KiB values fill one shard's 64 KiB share; pick keys that land in the same shard.
+	body := make([]byte, 10<<10)
+	shard := l1ShardFor(CacheKey("0"))
+	var keys []string
+	for i := 0; len(keys) < 7; i++ {
+		if k := CacheKey(strconv.Itoa(i)); l1ShardFor(k) == shard {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys[:6] {
+		l1Set(k, body, l1Gen.Load())
+	}
+	l1Set(keys[0], body, l1Gen.Load()) // refilled: now the newest
+	l1Set(keys[6], body, l1Gen.Load()) // over the cap: evicts the oldest fill
+	if _, ok := l1Get(keys[0]); !ok {
+		t.Fatal("refilled key was evicted as if it were the oldest")
+	}
+	if _, ok := l1Get(keys[1]); ok {
+		t.Fatal("oldest fill survived eviction")
+	}
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+
+	"million-rps/internal/models"
+)
+
+// TodoCache is the cache surface the controller and worker depend on. Redis is the production
+// implementation; Memory backs tests and single-process runs. List responses come back as an Entry carrying
+// the ETag stored with them, pre-compressed in the requested encoding when that variant is cached. Fills take
+// the Gen read before the database load, and are dropped if an invalidation happened since.
+type TodoCache interface {
+	TodosGen(ctx context.Context) Gen
+	UserTodosGen(ctx context.Context, userID string) Gen
+	TodoGen(ctx context.Context, id string) Gen
+
+	GetRawTodos(ctx context.Context, enc string) (Entry, bool)
+	SetRawTodosAsync(gen Gen, b []byte)
+	GetRawTodosLimit(ctx context.Context, limit int, enc string) (Entry, bool)
+	SetRawTodosLimitAsync(gen Gen, limit int, b []byte)
+	GetRawTodosFirstPage(ctx context.Context, limit int, enc string) (Entry, bool)
+	SetRawTodosFirstPageAsync(gen Gen, limit int, b []byte)
+	GetRawUserTodos(ctx context.Context, userID string, limit int, enc string) (Entry, bool)
+	SetRawUserTodosAsync(gen Gen, userID string, limit int, b []byte)
+	GetRawTodo(ctx context.Context, id string) ([]byte, bool)
+	SetRawTodoAsync(gen Gen, id string, b []byte)
+
+	InvalidateTodos(ctx context.Context)
+	InvalidateUserTodos(ctx context.Context, userID string)
+	InvalidateTodo(ctx context.Context, id string)
+	InvalidateBatch(ctx context.Context, todoIDs, userIDs []string)
+
+	SetCommandPending(ctx context.Context, cmd *models.TodoCommand)
+	SetCommandOutcome(ctx context.Context, cmd *models.TodoCommand, status, reason string)
+	GetCommandStatus(ctx context.Context, id string) (*models.CommandStatus, bool)
+}
+
+// Redis implements TodoCache with the package-level functions over Client.
+type Redis struct{}
+
+var _ TodoCache = Redis{}
+
+func (Redis) TodosGen(ctx context.Context) Gen                          { return TodosGen(ctx) }
+func (Redis) UserTodosGen(ctx context.Context, userID string) Gen       { return UserTodosGen(ctx, userID) }
+func (Redis) TodoGen(ctx context.Context, id string) Gen                { return TodoGen(ctx, id) }
+func (Redis) GetRawTodos(ctx context.Context, enc string) (Entry, bool) { return GetRawTodos(ctx, enc) }
+func (Redis) SetRawTodosAsync(gen Gen, b []byte)                        { SetRawTodosAsync(gen, b) }
+
+func (Redis) GetRawTodosLimit(ctx context.Context, limit int, enc string) (Entry, bool) {
+	return GetRawTodosLimit(ctx, limit, enc)
+}
+func (Redis) SetRawTodosLimitAsync(gen Gen, limit int, b []byte) {
+	SetRawTodosLimitAsync(gen, limit, b)
+}
+
+func (Redis) GetRawTodosFirstPage(ctx context.Context, limit int, enc string) (Entry, bool) {
+	return GetRawTodosFirstPage(ctx, limit, enc)
+}
+func (Redis) SetRawTodosFirstPageAsync(gen Gen, limit int, b []byte) {
+	SetRawTodosFirstPageAsync(gen, limit, b)
+}
+
+func (Redis) GetRawUserTodos(ctx context.Context, userID string, limit int, enc string) (Entry, bool) {
+	return GetRawUserTodos(ctx, userID, limit, enc)
+}
+func (Redis) SetRawUserTodosAsync(gen Gen, userID string, limit int, b []byte) {
+	SetRawUserTodosAsync(gen, userID, limit, b)
+}
+
+func (Redis) GetRawTodo(ctx context.Context, id string) ([]byte, bool) { return GetRawTodo(ctx, id) }
+func (Redis) SetRawTodoAsync(gen Gen, id string, b []byte)             { SetRawTodoAsync(gen, id, b) }
+
+func (Redis) InvalidateTodos(ctx context.Context) { InvalidateTodos(ctx) }
+func (Redis) InvalidateUserTodos(ctx context.Context, userID string) {
+	InvalidateUserTodos(ctx, userID)
+}
+func (Redis) InvalidateTodo(ctx context.Context, id string) { InvalidateTodo(ctx, id) }
+func (Redis) InvalidateBatch(ctx context.Context, todoIDs, userIDs []string) {
+	InvalidateBatch(ctx, todoIDs, userIDs)
+}
+
+func (Redis) SetCommandPending(ctx context.Context, cmd *models.TodoCommand) {
+	SetCommandPending(ctx, cmd)
+}
+func (Redis) SetCommandOutcome(ctx context.Context, cmd *models.TodoCommand, status, reason string) {
+	SetCommandOutcome(ctx, cmd, status, reason)
+}
+func (Redis) GetCommandStatus(ctx context.Context, id string) (*models.CommandStatus, bool) {
+	return GetCommandStatus(ctx, id)
+}
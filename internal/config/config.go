@@ -4,22 +4,58 @@ import (
 	"context"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 )
 
 // Config holds application configuration from environment.
 type Config struct {
-	HTTPPort        string
-	DatabaseURL     string
-	DBPoolSize      int
-	RedisURL        string
-	RedisPoolSize   int
-	CacheTTL        int // seconds
-	KafkaBrokers    string
-	KafkaTopic      string
-	KafkaPartitions int
-	WorkerPoolSize  int
-	JWTSecret       string
+	HTTPPort              string
+	DatabaseURL           string
+	DBPoolSize            int
+	RedisURL              string
+	RedisPoolSize         int
+	CacheTTL              int    // seconds
+	CacheL1TTL            int    // milliseconds an in-process copy of a Redis value is served; 0 disables the L1
+	CacheL1MaxMB          int    // size cap of the in-process L1
+	CommandTTL            int    // seconds; how long command outcomes stay queryable
+	QueueBackend          string // kafka, redis or memory
+	QueueMemoryBuffer     int    // capacity of the in-process queue
+	RedisStream           string // stream name prefix for QUEUE_BACKEND=redis
+	RedisStreamDLQ        string
+	RedisStreamShards     int   // streams commands are spread over; each is consumed by one worker at a time
+	RedisStreamDLQMaxLen  int64 // approximate entries kept in the dead-letter stream
+	RedisStreamLease      int   // milliseconds a worker's claim on a shard lasts without renewal
+	KafkaBrokers          string
+	KafkaTopic            string
+	KafkaPartitions       int
+	KafkaDLQTopic         string
+	KafkaRequiredAcks     string   // none, one, all
+	KafkaSyncActions      []string // command actions published synchronously (wait for broker ack)
+	KafkaPartitionKey     string   // todo or user; commands with the same key are applied in order
+	KafkaClientID         string
+	KafkaReplication      int    // replication factor for topics created at startup
+	KafkaCompression      string // none, gzip, snappy, lz4 or zstd
+	KafkaSASLMechanism    string // empty (no SASL), plain, scram-sha-256 or scram-sha-512
+	KafkaSASLUsername     string
+	KafkaSASLPassword     string
+	KafkaTLS              bool
+	KafkaTLSCAFile        string // PEM bundle trusted instead of the system roots
+	KafkaTLSSkipVerify    bool
+	WorkerPoolSize        int
+	WorkerMaxAttempts     int // attempts per message for transient errors before dead-lettering
+	WorkerRetryBackoff    int // milliseconds; doubled per attempt
+	WorkerRetryMaxBackoff int // milliseconds
+	WorkerBatchSize       int // commands applied per DB transaction; 1 disables batching
+	WorkerBatchWait       int // milliseconds a lane waits to fill a batch
+	ProcessedRetention    int // hours processed command ids are kept for deduplicating redeliveries
+	JWTSecret             string
+	ShutdownTimeout       int  // seconds; total budget for draining HTTP, Kafka, worker and pools
+	ValidateWrites        bool // check id existence/ownership in PUT/DELETE before enqueueing
+	OutboxEnabled         bool // store accepted commands in Postgres and relay them to Kafka
+	OutboxBatchSize       int  // commands relayed per transaction
+	OutboxPollInterval    int  // milliseconds between relay polls when the outbox is drained
+	OutboxRetention       int  // hours sent outbox rows are kept
 }
 
 var (
@@ -31,17 +67,52 @@ var (
 func Get() *Config {
 	cfgOnce.Do(func() {
 		cfg = &Config{
-			HTTPPort:        getEnv("HTTP_PORT", "8080"),
-			DatabaseURL:     getEnv("DATABASE_URL", ""),
-			DBPoolSize:      getIntEnv("DB_POOL_SIZE", 5000),
-			RedisURL:        getEnv("REDIS_URL", "redis://localhost:6379/0"),
-			RedisPoolSize:   getIntEnv("REDIS_POOL_SIZE", 5000),
-			CacheTTL:        getIntEnv("CACHE_TTL_SEC", 300),
-			KafkaBrokers:    getEnv("KAFKA_BROKERS", "localhost:9092"),
-			KafkaTopic:      getEnv("KAFKA_TODO_TOPIC", "todo-commands"),
-			KafkaPartitions: getIntEnv("KAFKA_PARTITIONS", 32),
-			WorkerPoolSize:  getIntEnv("WORKER_POOL_SIZE", 128),
-			JWTSecret:       getEnv("JWT_SECRET", ""),
+			HTTPPort:              getEnv("HTTP_PORT", "8080"),
+			DatabaseURL:           getEnv("DATABASE_URL", ""),
+			DBPoolSize:            getIntEnv("DB_POOL_SIZE", 5000),
+			RedisURL:              getEnv("REDIS_URL", "redis://localhost:6379/0"),
+			RedisPoolSize:         getIntEnv("REDIS_POOL_SIZE", 5000),
+			CacheTTL:              getIntEnv("CACHE_TTL_SEC", 300),
+			CacheL1TTL:            getIntEnv("CACHE_L1_TTL_MS", 1000),
+			CacheL1MaxMB:          getIntEnv("CACHE_L1_MAX_MB", 64),
+			CommandTTL:            getIntEnv("COMMAND_STATUS_TTL_SEC", 3600),
+			QueueBackend:          getEnv("QUEUE_BACKEND", "kafka"),
+			QueueMemoryBuffer:     getIntEnv("QUEUE_MEMORY_BUFFER", 10000),
+			RedisStream:           getEnv("REDIS_STREAM", "todo-commands"),
+			RedisStreamDLQ:        getEnv("REDIS_STREAM_DLQ", "todo-commands-dlq"),
+			RedisStreamShards:     getIntEnv("REDIS_STREAM_SHARDS", 16),
+			RedisStreamDLQMaxLen:  int64(getIntEnv("REDIS_STREAM_DLQ_MAXLEN", 1000000)),
+			RedisStreamLease:      getIntEnv("REDIS_STREAM_LEASE_MS", 10000),
+			KafkaBrokers:          getEnv("KAFKA_BROKERS", "localhost:9092"),
+			KafkaTopic:            getEnv("KAFKA_TODO_TOPIC", "todo-commands"),
+			KafkaPartitions:       getIntEnv("KAFKA_PARTITIONS", 32),
+			KafkaDLQTopic:         getEnv("KAFKA_DLQ_TOPIC", "todo-commands-dlq"),
+			KafkaRequiredAcks:     getEnv("KAFKA_REQUIRED_ACKS", "one"),
+			KafkaSyncActions:      getListEnv("KAFKA_SYNC_ACTIONS"),
+			KafkaPartitionKey:     getEnv("KAFKA_PARTITION_KEY", "todo"),
+			KafkaClientID:         getEnv("KAFKA_CLIENT_ID", "million-rps"),
+			KafkaReplication:      getIntEnv("KAFKA_REPLICATION_FACTOR", 1),
+			KafkaCompression:      getEnv("KAFKA_COMPRESSION", "none"),
+			KafkaSASLMechanism:    getEnv("KAFKA_SASL_MECHANISM", ""),
+			KafkaSASLUsername:     getEnv("KAFKA_SASL_USERNAME", ""),
+			KafkaSASLPassword:     getEnv("KAFKA_SASL_PASSWORD", ""),
+			KafkaTLS:              getBoolEnv("KAFKA_TLS", false),
+			KafkaTLSCAFile:        getEnv("KAFKA_TLS_CA_FILE", ""),
+			KafkaTLSSkipVerify:    getBoolEnv("KAFKA_TLS_INSECURE_SKIP_VERIFY", false),
+			WorkerPoolSize:        getIntEnv("WORKER_POOL_SIZE", 128),
+			WorkerMaxAttempts:     getIntEnv("WORKER_MAX_ATTEMPTS", 5),
+			WorkerRetryBackoff:    getIntEnv("WORKER_RETRY_BACKOFF_MS", 100),
+			WorkerRetryMaxBackoff: getIntEnv("WORKER_RETRY_MAX_BACKOFF_MS", 5000),
+			WorkerBatchSize:       getIntEnv("WORKER_BATCH_SIZE", 100),
+			WorkerBatchWait:       getIntEnv("WORKER_BATCH_MS", 5),
+			ProcessedRetention:    getIntEnv("PROCESSED_COMMANDS_RETENTION_HOURS", 168),
+			JWTSecret:             getEnv("JWT_SECRET", ""),
+			ShutdownTimeout:       getIntEnv("SHUTDOWN_TIMEOUT_SEC", 25),
+			ValidateWrites:        getBoolEnv("VALIDATE_WRITES", false),
+			OutboxEnabled:         getBoolEnv("OUTBOX_ENABLED", false),
+			OutboxBatchSize:       getIntEnv("OUTBOX_BATCH_SIZE", 500),
+			OutboxPollInterval:    getIntEnv("OUTBOX_POLL_MS", 50),
+			OutboxRetention:       getIntEnv("OUTBOX_RETENTION_HOURS", 168),
 		}
 	})
 	return cfg
@@ -59,6 +130,26 @@ func getEnv(key, defaultVal string) string {
 	return defaultVal
 }
 
+func getBoolEnv(key string, defaultVal bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return defaultVal
+}
+
+// getListEnv splits a comma-separated env var, dropping empty items.
+func getListEnv(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func getIntEnv(key string, defaultVal int) int {
 	if v := os.Getenv(key); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
@@ -6,10 +6,13 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"million-rps/internal/cache"
+	"million-rps/internal/config"
 	"million-rps/internal/database"
+	"million-rps/internal/metrics"
 	"million-rps/internal/models"
 	"million-rps/internal/queue"
 	"million-rps/internal/repository"
@@ -20,26 +23,55 @@ import (
 	"golang.org/x/sync/singleflight"
 )
 
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
 var getTodosGroup singleflight.Group
 
+// Handler dependencies. Defaults are the production Postgres, Redis and Kafka implementations;
+// tests swap them with Use.
+var (
+	store     repository.TodoStore   = repository.Postgres{}
+	todoCache cache.TodoCache        = cache.Redis{}
+	publisher queue.CommandPublisher = queue.Kafka{}
+)
+
+// Use replaces the store, cache and publisher used by the handlers. Call before serving traffic.
+func Use(s repository.TodoStore, tc cache.TodoCache, p queue.CommandPublisher) {
+	store, todoCache, publisher = s, tc, p
+}
+
 // GetTodos is the public handler: returns todos as JSON (cache-first as raw bytes for max throughput). Supports ?limit=N for pagination (smaller payload = higher RPS).
+// Passing ?cursor= (empty for the first page) switches to keyset pagination with a {items, next_cursor} envelope.
 func GetTodos(c *gin.Context) {
 	ctx := c.Request.Context()
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		getTodosPage(c, limit, cursor)
+		return
+	}
 
 	if limit > 0 {
-		if b, ok := cache.GetRawTodosLimit(ctx, limit); ok {
-			c.Data(http.StatusOK, "application/json", b)
+		if e, ok := todoCache.GetRawTodosLimit(ctx, limit, acceptedEncoding(c)); ok {
+			writeList(c, e, "public")
 			return
 		}
 		key := "todos:limit:" + strconv.Itoa(limit)
-		v, err, _ := getTodosGroup.Do(key, func() (interface{}, error) {
-			todos, err := repository.GetRange(context.Background(), limit, 0)
+		v, err, shared := getTodosGroup.Do(key, func() (interface{}, error) {
+			gen := todoCache.TodosGen(context.Background())
+			todos, err := store.GetRange(context.Background(), limit, 0)
 			if err != nil {
 				return nil, err
 			}
-			return json.Marshal(todos)
+			e, err := listEntry(todos)
+			if err == nil {
+				go todoCache.SetRawTodosLimitAsync(gen, limit, e.Body)
+			}
+			return e, err
 		})
+		metrics.SingleflightCall(shared)
 		if err != nil {
 			if ctx.Err() != nil || isContextErr(err) {
 				return
@@ -48,23 +80,28 @@ func GetTodos(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get todos"})
 			return
 		}
-		b := v.([]byte)
-		c.Data(http.StatusOK, "application/json", b)
-		go cache.SetRawTodosLimitAsync(limit, b)
+		e := v.(cache.Entry)
+		writeList(c, e, "public")
 		return
 	}
 
-	if b, ok := cache.GetRawTodos(ctx); ok {
-		c.Data(http.StatusOK, "application/json", b)
+	if e, ok := todoCache.GetRawTodos(ctx, acceptedEncoding(c)); ok {
+		writeList(c, e, "public")
 		return
 	}
-	v, err, _ := getTodosGroup.Do("todos", func() (interface{}, error) {
-		todos, err := repository.GetAll(context.Background())
+	v, err, shared := getTodosGroup.Do("todos", func() (interface{}, error) {
+		gen := todoCache.TodosGen(context.Background())
+		todos, err := store.GetAll(context.Background())
 		if err != nil {
 			return nil, err
 		}
-		return json.Marshal(todos)
+		e, err := listEntry(todos)
+		if err == nil {
+			go todoCache.SetRawTodosAsync(gen, e.Body)
+		}
+		return e, err
 	})
+	metrics.SingleflightCall(shared)
 	if err != nil {
 		if ctx.Err() != nil || isContextErr(err) {
 			return
@@ -73,9 +110,248 @@ func GetTodos(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get todos"})
 		return
 	}
-	b := v.([]byte)
+	e := v.(cache.Entry)
+	writeList(c, e, "public")
+}
+
+// listEntry marshals a list response and computes its ETag once, so callers sharing a singleflight don't rehash it.
+func listEntry(v any) (cache.Entry, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	return cache.Entry{Body: b, ETag: cache.ETag(b)}, nil
+}
+
+// writeList sends a list response with its ETag, or 304 Not Modified if If-None-Match already names it.
+// Cache-Control (scope is "public" or "private") makes clients revalidate on every poll, which costs only
+// a 304 while nothing changed, and lets them fall back to their copy for CACHE_TTL_SEC if the API errors.
+// Pre-compressed bodies are sent as they are, with Content-Encoding.
+func writeList(c *gin.Context, e cache.Entry, scope string) {
+	c.Header("ETag", e.ETag)
+	c.Header("Cache-Control", scope+", no-cache, stale-if-error="+strconv.Itoa(config.Get().CacheTTL))
+	c.Header("Vary", "Accept-Encoding")
+	if etagMatches(c.GetHeader("If-None-Match"), e.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if e.Encoding != cache.Identity {
+		c.Header("Content-Encoding", e.Encoding)
+	}
+	c.Data(http.StatusOK, "application/json", e.Body)
+}
+
+// acceptedEncoding returns the first of cache.Encodings the request's Accept-Encoding allows (q > 0, or
+// via "*"), or cache.Identity.
+func acceptedEncoding(c *gin.Context) string {
+	header := c.GetHeader("Accept-Encoding")
+	if header == "" {
+		return cache.Identity
+	}
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, enc := range cache.Encodings {
+		if ok, listed := accepted[enc]; ok || (!listed && accepted["*"]) {
+			return enc
+		}
+	}
+	return cache.Identity
+}
+
+// etagMatches reports whether an If-None-Match header lists etag (weak comparison, "*" matches anything).
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTodo (auth) returns one of the caller's todos by id (cache-first as raw bytes, singleflight on miss).
+// 404 on unknown ids and on other users' todos, so their ids are not confirmed.
+func GetTodo(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, _ := c.Get("user")
+	uid, _ := userID.(string)
+	if uid == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing todo id"})
+		return
+	}
+
+	if b, ok := todoCache.GetRawTodo(ctx, id); ok {
+		writeTodo(c, b, uid)
+		return
+	}
+	v, err, shared := getTodosGroup.Do(cache.CacheKey(id), func() (interface{}, error) {
+		gen := todoCache.TodoGen(context.Background(), id)
+		todo, err := store.GetByID(context.Background(), id)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(todo)
+		if err == nil {
+			go todoCache.SetRawTodoAsync(gen, id, b)
+		}
+		return b, err
+	})
+	metrics.SingleflightCall(shared)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+			return
+		}
+		if ctx.Err() != nil || isContextErr(err) {
+			return
+		}
+		logger.Error(ctx, "GetTodo repository failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get todo"})
+		return
+	}
+	writeTodo(c, v.([]byte), uid)
+}
+
+// writeTodo sends a single todo's JSON with its version as ETag, for use in If-Match on PUT/DELETE, or 404
+// if the todo does not belong to uid.
+func writeTodo(c *gin.Context, b []byte, uid string) {
+	var v struct {
+		UserID  string `json:"user_id"`
+		Version int64  `json:"version"`
+	}
+	if json.Unmarshal(b, &v) != nil || v.UserID != uid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+		return
+	}
+	if v.Version > 0 {
+		c.Header("ETag", versionETag(v.Version))
+	}
 	c.Data(http.StatusOK, "application/json", b)
-	go cache.SetRawTodosAsync(b)
+}
+
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch reads an If-Match version ("3", W/"3" or 3). Absent or "*" means no precondition.
+func parseIfMatch(c *gin.Context) (*int64, bool) {
+	h := strings.TrimSpace(c.GetHeader("If-Match"))
+	if h == "" || h == "*" {
+		return nil, true
+	}
+	h = strings.Trim(strings.TrimPrefix(h, "W/"), `"`)
+	v, err := strconv.ParseInt(h, 10, 64)
+	if err != nil || v < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match version"})
+		return nil, false
+	}
+	return &v, true
+}
+
+// GetMyTodos (auth): returns the caller's todos (JWT subject), cache-first per user. Supports ?limit=N.
+func GetMyTodos(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, _ := c.Get("user")
+	uid, _ := userID.(string)
+	if uid == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if limit < 0 {
+		limit = 0
+	}
+
+	if e, ok := todoCache.GetRawUserTodos(ctx, uid, limit, acceptedEncoding(c)); ok {
+		writeList(c, e, "private")
+		return
+	}
+	key := "todos:user:" + uid + ":limit:" + strconv.Itoa(limit)
+	v, err, shared := getTodosGroup.Do(key, func() (interface{}, error) {
+		gen := todoCache.UserTodosGen(context.Background(), uid)
+		todos, err := store.GetByUser(context.Background(), uid, limit)
+		if err != nil {
+			return nil, err
+		}
+		e, err := listEntry(todos)
+		if err == nil {
+			go todoCache.SetRawUserTodosAsync(gen, uid, limit, e.Body)
+		}
+		return e, err
+	})
+	metrics.SingleflightCall(shared)
+	if err != nil {
+		if ctx.Err() != nil || isContextErr(err) {
+			return
+		}
+		logger.Error(ctx, "GetMyTodos repository failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get todos"})
+		return
+	}
+	e := v.(cache.Entry)
+	writeList(c, e, "private")
+}
+
+// getTodosPage serves one keyset page. Only the first page is cached; deeper pages go straight to the index seek.
+func getTodosPage(c *gin.Context, limit int, cursor string) {
+	ctx := c.Request.Context()
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	if cursor == "" {
+		if e, ok := todoCache.GetRawTodosFirstPage(ctx, limit, acceptedEncoding(c)); ok {
+			writeList(c, e, "public")
+			return
+		}
+	}
+	key := "todos:page:" + strconv.Itoa(limit) + ":" + cursor
+	v, err, shared := getTodosGroup.Do(key, func() (interface{}, error) {
+		var gen cache.Gen
+		if cursor == "" {
+			gen = todoCache.TodosGen(context.Background())
+		}
+		page, err := store.GetPage(context.Background(), limit, cursor)
+		if err != nil {
+			return nil, err
+		}
+		e, err := listEntry(page)
+		if err == nil && cursor == "" {
+			go todoCache.SetRawTodosFirstPageAsync(gen, limit, e.Body)
+		}
+		return e, err
+	})
+	metrics.SingleflightCall(shared)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		if ctx.Err() != nil || isContextErr(err) {
+			return
+		}
+		logger.Error(ctx, "GetTodos page repository failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get todos"})
+		return
+	}
+	e := v.(cache.Entry)
+	writeList(c, e, "public")
 }
 
 func isContextErr(err error) bool {
@@ -87,21 +363,23 @@ func Health(c *gin.Context) {
 	c.String(http.StatusOK, "OK")
 }
 
-// Ready returns 200 if DB and Redis are reachable. Used by K8s readiness probes.
+// Ready returns 200 if DB and Redis are reachable and Kafka deliveries are not failing. Used by K8s readiness probes.
 func Ready(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
 	defer cancel()
-	if cache.Client(ctx) == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "redis unavailable"})
+	if err := cache.Ping(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "redis unavailable", "error": err.Error()})
 		return
 	}
-	db := database.DB(ctx)
-	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "database unavailable"})
-		return
+	// In outbox mode the publisher is Postgres itself, and the relay rides out queue failures.
+	if q, ok := publisher.(queue.CommandQueue); ok {
+		if err := q.Healthy(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "command queue failing", "error": err.Error()})
+			return
+		}
 	}
-	if err := db.PingContext(ctx); err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "database ping failed"})
+	if err := database.Ping(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "database unavailable", "error": err.Error()})
 		return
 	}
 	c.String(http.StatusOK, "OK")
@@ -126,6 +404,7 @@ func CreateTodo(c *gin.Context) {
 	}
 	id := uuid.New().String()
 	cmd := &models.TodoCommand{
+		CommandID:   uuid.New().String(),
 		Action:      "create",
 		ID:          id,
 		Title:       body.Title,
@@ -133,12 +412,12 @@ func CreateTodo(c *gin.Context) {
 		UserID:      uid,
 		RequestedAt: time.Now(),
 	}
-	if err := queue.PublishTodoCommand(ctx, cmd); err != nil {
+	if err := publisher.PublishTodoCommand(ctx, cmd); err != nil {
 		logger.Error(ctx, "CreateTodo publish failed", "error", err)
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request queued failed"})
 		return
 	}
-	c.JSON(http.StatusAccepted, gin.H{"id": id, "message": "Todo creation queued"})
+	accepted(c, cmd, "Todo creation queued")
 }
 
 // UpdateTodo (auth): publishes update command to Kafka, returns 202.
@@ -164,21 +443,34 @@ func UpdateTodo(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	if body.Title == "" && body.Description == "" && body.Completed == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+	expected, ok := parseIfMatch(c)
+	if !ok {
+		return
+	}
+	if !checkOwner(c, id, uid, expected) {
+		return
+	}
 	cmd := &models.TodoCommand{
-		Action:      "update",
-		ID:          id,
-		Title:       body.Title,
-		Description: body.Description,
-		Completed:   body.Completed,
-		UserID:      uid,
-		RequestedAt: time.Now(),
+		CommandID:       uuid.New().String(),
+		Action:          "update",
+		ID:              id,
+		Title:           body.Title,
+		Description:     body.Description,
+		Completed:       body.Completed,
+		UserID:          uid,
+		ExpectedVersion: expected,
+		RequestedAt:     time.Now(),
 	}
-	if err := queue.PublishTodoCommand(ctx, cmd); err != nil {
+	if err := publisher.PublishTodoCommand(ctx, cmd); err != nil {
 		logger.Error(ctx, "UpdateTodo publish failed", "error", err)
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request queued failed"})
 		return
 	}
-	c.JSON(http.StatusAccepted, gin.H{"id": id, "message": "Todo update queued"})
+	accepted(c, cmd, "Todo update queued")
 }
 
 // DeleteTodo (auth): publishes delete command to Kafka, returns 202.
@@ -195,16 +487,92 @@ func DeleteTodo(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing todo id"})
 		return
 	}
+	expected, ok := parseIfMatch(c)
+	if !ok {
+		return
+	}
+	if !checkOwner(c, id, uid, expected) {
+		return
+	}
 	cmd := &models.TodoCommand{
-		Action:      "delete",
-		ID:          id,
-		UserID:      uid,
-		RequestedAt: time.Now(),
+		CommandID:       uuid.New().String(),
+		Action:          "delete",
+		ID:              id,
+		UserID:          uid,
+		ExpectedVersion: expected,
+		RequestedAt:     time.Now(),
 	}
-	if err := queue.PublishTodoCommand(ctx, cmd); err != nil {
+	if err := publisher.PublishTodoCommand(ctx, cmd); err != nil {
 		logger.Error(ctx, "DeleteTodo publish failed", "error", err)
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Request queued failed"})
 		return
 	}
-	c.JSON(http.StatusAccepted, gin.H{"id": id, "message": "Todo deletion queued"})
+	accepted(c, cmd, "Todo deletion queued")
+}
+
+// checkOwner is the optional (VALIDATE_WRITES) synchronous check for PUT/DELETE: it answers 404 for unknown ids,
+// 403 for todos owned by someone else and 412 when If-Match is already stale, reading the per-item cache before
+// Postgres. Returns false if it already wrote a response. Todos whose create is still queued are reported as 404.
+func checkOwner(c *gin.Context, id, uid string, expected *int64) bool {
+	if !config.Get().ValidateWrites {
+		return true
+	}
+	ctx := c.Request.Context()
+	var todo *models.Todo
+	if b, ok := todoCache.GetRawTodo(ctx, id); ok {
+		var t models.Todo
+		if json.Unmarshal(b, &t) == nil {
+			todo = &t
+		}
+	}
+	if todo == nil {
+		t, err := store.GetByID(ctx, id)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			if ctx.Err() == nil {
+				logger.Error(ctx, "Write validation lookup failed", "error", err, "id", id)
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to validate todo"})
+			}
+			return false
+		}
+		todo = t
+	}
+	switch err := repository.CheckOwner(todo, uid); {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Todo not found"})
+		return false
+	case errors.Is(err, repository.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return false
+	}
+	if expected != nil && todo.Version != *expected {
+		c.Header("ETag", versionETag(todo.Version))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Todo version changed", "version": todo.Version})
+		return false
+	}
+	return true
+}
+
+// accepted records the command as pending, then answers 202 with a Location pointing at its status, so the
+// status exists by the time the client can poll it.
+func accepted(c *gin.Context, cmd *models.TodoCommand, message string) {
+	todoCache.SetCommandPending(c.Request.Context(), cmd)
+	c.Header("Location", "/commands/"+cmd.CommandID)
+	c.JSON(http.StatusAccepted, gin.H{"id": cmd.ID, "command_id": cmd.CommandID, "message": message})
+}
+
+// GetCommand (auth): returns the outcome (pending/applied/failed/rejected/conflict) of a previously accepted write command.
+func GetCommand(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, _ := c.Get("user")
+	uid, _ := userID.(string)
+	if uid == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	st, ok := todoCache.GetCommandStatus(ctx, c.Param("id"))
+	if !ok || st.UserID != uid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Command not found"})
+		return
+	}
+	c.JSON(http.StatusOK, st)
 }
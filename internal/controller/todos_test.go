@@ -0,0 +1,302 @@
+package controller
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"million-rps/internal/cache"
+	"million-rps/internal/config"
+	"million-rps/internal/models"
+	"million-rps/internal/queue"
+	"million-rps/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fixture struct {
+	store *repository.Memory
+	cache *cache.Memory
+	queue *queue.Memory
+	r     *gin.Engine
+}
+
+// newFixture wires the handlers to in-memory dependencies. The "user" is taken from X-User instead of a JWT.
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	f := &fixture{store: repository.NewMemory(), cache: cache.NewMemory(), queue: &queue.Memory{}}
+	Use(f.store, f.cache, f.queue)
+	t.Cleanup(func() { Use(repository.Postgres{}, cache.Redis{}, queue.Kafka{}) })
+
+	f.r = gin.New()
+	f.r.GET("/todos", GetTodos)
+	auth := f.r.Group("", func(c *gin.Context) { c.Set("user", c.GetHeader("X-User")) })
+	auth.GET("/todos/:id", GetTodo)
+	auth.GET("/me/todos", GetMyTodos)
+	auth.POST("/todos", CreateTodo)
+	auth.PUT("/todos/:id", UpdateTodo)
+	auth.DELETE("/todos/:id", DeleteTodo)
+	auth.GET("/commands/:id", GetCommand)
+	return f
+}
+
+func (f *fixture) do(method, path, user, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	w := httptest.NewRecorder()
+	f.r.ServeHTTP(w, req)
+	return w
+}
+
+// waitFor polls cond until it holds; cache fills happen in a background goroutine.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetTodosFillsCacheThenServesFromIt(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	_ = f.store.Create(ctx, &models.Todo{ID: "t1", Title: "a", UserID: "u"})
+
+	w := f.do(http.MethodGet, "/todos?limit=10", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	waitFor(t, "todos:limit:10 fill", func() bool { _, ok := f.cache.GetRawTodosLimit(ctx, 10, cache.Identity); return ok })
+
+	// A cache hit must not touch the store.
+	_, _ = f.store.ApplyBatch(ctx, []*models.TodoCommand{{Action: "delete", ID: "t1", UserID: "u"}})
+	w = f.do(http.MethodGet, "/todos?limit=10", "", "")
+	var todos []models.Todo
+	if err := json.Unmarshal(w.Body.Bytes(), &todos); err != nil || len(todos) != 1 {
+		t.Fatalf("cached body = %s (%v), want the one cached todo", w.Body, err)
+	}
+}
+
+func TestGetTodosETagAnswersNotModified(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	_ = f.store.Create(ctx, &models.Todo{ID: "t1", Title: "a", UserID: "u"})
+
+	miss := f.do(http.MethodGet, "/todos?limit=100", "", "")
+	etag := miss.Header().Get("ETag")
+	if miss.Code != http.StatusOK || etag == "" || !strings.Contains(miss.Header().Get("Cache-Control"), "no-cache") {
+		t.Fatalf("status = %d, ETag = %q, Cache-Control = %q", miss.Code, etag, miss.Header().Get("Cache-Control"))
+	}
+	waitFor(t, "todos:limit:100 fill", func() bool { _, ok := f.cache.GetRawTodosLimit(ctx, 100, cache.Identity); return ok })
+
+	// The cache hit carries the same validator, so a poll with it costs a bodiless 304.
+	req := httptest.NewRequest(http.MethodGet, "/todos?limit=100", nil)
+	req.Header.Set("If-None-Match", `"stale", W/`+etag)
+	w := httptest.NewRecorder()
+	f.r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Fatalf("revalidation = %d with %d bytes and ETag %q, want 304 with %q", w.Code, w.Body.Len(), w.Header().Get("ETag"), etag)
+	}
+
+	f.cache.InvalidateTodos(ctx)
+	_ = f.store.Create(ctx, &models.Todo{ID: "t2", Title: "b", UserID: "u"})
+	req = httptest.NewRequest(http.MethodGet, "/todos?limit=100", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	f.r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("after a change: status %d, ETag %q, want 200 with a new ETag", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestGetTodosServesPrecompressedVariant(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	_ = f.store.Create(ctx, &models.Todo{ID: "t1", Title: "a", UserID: "u"})
+	plain := f.do(http.MethodGet, "/todos?limit=100", "", "")
+	waitFor(t, "todos:limit:100 fill", func() bool { _, ok := f.cache.GetRawTodosLimit(ctx, 100, cache.Identity); return ok })
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/todos?limit=100", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		f.r.ServeHTTP(w, req)
+		return w
+	}
+	w := get("gzip, deflate, br")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("ETag") == plain.Header().Get("ETag") {
+		t.Fatalf("Content-Encoding = %q, ETag %q (plain %q)", w.Header().Get("Content-Encoding"), w.Header().Get("ETag"), plain.Header().Get("ETag"))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != plain.Body.String() {
+		t.Fatalf("gunzipped body = %s, want %s", body, plain.Body)
+	}
+	if enc := get("zstd;q=0, gzip;q=0.5").Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("zstd refused: Content-Encoding = %q, want gzip", enc)
+	}
+	if enc := get("*").Header().Get("Content-Encoding"); enc != "zstd" {
+		t.Fatalf("Accept-Encoding * got %q, want zstd", enc)
+	}
+	if w := get("identity"); w.Header().Get("Content-Encoding") != "" || w.Body.String() != plain.Body.String() {
+		t.Fatalf("identity response encoded as %q", w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestGetTodoNotFound(t *testing.T) {
+	f := newFixture(t)
+	if w := f.do(http.MethodGet, "/todos/missing", "u", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestGetTodosRejectsBadCursor(t *testing.T) {
+	f := newFixture(t)
+	if w := f.do(http.MethodGet, "/todos?limit=5&cursor=!!!", "", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestCreateTodoQueuesCommandWithLocation(t *testing.T) {
+	f := newFixture(t)
+	w := f.do(http.MethodPost, "/todos", "u", `{"title":"hello"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	cmds := f.queue.Drain()
+	if len(cmds) != 1 || cmds[0].Action != "create" || cmds[0].UserID != "u" || cmds[0].CommandID == "" {
+		t.Fatalf("queued %+v", cmds)
+	}
+	if loc := w.Header().Get("Location"); loc != "/commands/"+cmds[0].CommandID {
+		t.Fatalf("Location = %q", loc)
+	}
+	// The pending status is recorded before the 202, so an immediate poll finds it.
+	if w := f.do(http.MethodGet, w.Header().Get("Location"), "u", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"pending"`) {
+		t.Fatalf("immediate poll = %d %s, want pending", w.Code, w.Body)
+	}
+}
+
+func TestCreateTodoPublishFailure(t *testing.T) {
+	f := newFixture(t)
+	f.queue.Err = errors.New("broker down")
+	if w := f.do(http.MethodPost, "/todos", "u", `{"title":"hello"}`); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+}
+
+func TestCreateTodoRequiresTitle(t *testing.T) {
+	f := newFixture(t)
+	if w := f.do(http.MethodPost, "/todos", "u", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestGetCommandHidesOtherUsersCommands(t *testing.T) {
+	f := newFixture(t)
+	f.cache.SetCommandOutcome(context.Background(),
+		&models.TodoCommand{CommandID: "c1", Action: "create", ID: "t1", UserID: "alice"}, models.CommandApplied, "")
+
+	if w := f.do(http.MethodGet, "/commands/c1", "bob", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("bob status = %d, want 404", w.Code)
+	}
+	w := f.do(http.MethodGet, "/commands/c1", "alice", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"applied"`) {
+		t.Fatalf("alice got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestUpdateTodoRejectsEmptyBody(t *testing.T) {
+	f := newFixture(t)
+	if w := f.do(http.MethodPut, "/todos/t1", "u", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestValidateWritesChecksOwnership(t *testing.T) {
+	f := newFixture(t)
+	cfg := config.Get()
+	cfg.ValidateWrites = true
+	t.Cleanup(func() { cfg.ValidateWrites = false })
+	_ = f.store.Create(context.Background(), &models.Todo{ID: "t1", Title: "a", UserID: "alice"})
+
+	if w := f.do(http.MethodPut, "/todos/missing", "alice", `{"title":"b"}`); w.Code != http.StatusNotFound {
+		t.Errorf("unknown id status = %d, want 404", w.Code)
+	}
+	if w := f.do(http.MethodDelete, "/todos/t1", "bob", ""); w.Code != http.StatusForbidden {
+		t.Errorf("foreign delete status = %d, want 403", w.Code)
+	}
+	if w := f.do(http.MethodDelete, "/todos/t1", "alice", ""); w.Code != http.StatusAccepted {
+		t.Errorf("owner delete status = %d, want 202", w.Code)
+	}
+	if n := len(f.queue.Drain()); n != 1 {
+		t.Errorf("queued %d commands, want only the owner's", n)
+	}
+}
+
+func TestIfMatchIsCarriedInCommand(t *testing.T) {
+	f := newFixture(t)
+	req := httptest.NewRequest(http.MethodPut, "/todos/t1", strings.NewReader(`{"title":"b"}`))
+	req.Header.Set("X-User", "u")
+	req.Header.Set("If-Match", `W/"3"`)
+	w := httptest.NewRecorder()
+	f.r.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d", w.Code)
+	}
+	cmds := f.queue.Drain()
+	if len(cmds) != 1 || cmds[0].ExpectedVersion == nil || *cmds[0].ExpectedVersion != 3 {
+		t.Fatalf("queued %+v, want expected_version 3", cmds)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/todos/t1", nil)
+	req.Header.Set("X-User", "u")
+	req.Header.Set("If-Match", `"abc"`)
+	w = httptest.NewRecorder()
+	f.r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("bad If-Match status = %d, want 400", w.Code)
+	}
+}
+
+func TestGetTodoSendsVersionETag(t *testing.T) {
+	f := newFixture(t)
+	_ = f.store.Create(context.Background(), &models.Todo{ID: "t1", Title: "a", UserID: "u"})
+	w := f.do(http.MethodGet, "/todos/t1", "u", "")
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("status %d ETag %q, want 200 \"1\"", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestGetTodoHidesOtherUsersTodos(t *testing.T) {
+	f := newFixture(t)
+	_ = f.store.Create(context.Background(), &models.Todo{ID: "t1", Title: "a", UserID: "alice"})
+	if w := f.do(http.MethodGet, "/todos/t1", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous status = %d, want 401", w.Code)
+	}
+	// Twice, so the second read is served from the cache filled by the first.
+	for range 2 {
+		if w := f.do(http.MethodGet, "/todos/t1", "bob", ""); w.Code != http.StatusNotFound {
+			t.Fatalf("foreign status = %d, want 404", w.Code)
+		}
+		waitFor(t, "todo cache fill", func() bool { _, ok := f.cache.GetRawTodo(context.Background(), "t1"); return ok })
+	}
+	if w := f.do(http.MethodGet, "/todos/t1", "alice", ""); w.Code != http.StatusOK {
+		t.Fatalf("owner status = %d, want 200", w.Code)
+	}
+}
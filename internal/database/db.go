@@ -3,67 +3,71 @@ package database
 import (
 	"context"
 	"database/sql"
-	"sync"
+	"errors"
 
 	"million-rps/internal/config"
+	"million-rps/internal/lazyconn"
 	"million-rps/pkg/logger"
 
 	_ "github.com/lib/pq"
 )
 
-var (
-	pool *sql.DB
-	once sync.Once
-)
+// ErrUnavailable is returned by Ping while no pool could be connected yet.
+var ErrUnavailable = errors.New("database unavailable")
+
+var pool = newPool()
 
-// DB returns the global database connection pool (initialized on first use).
+func newPool() *lazyconn.Conn[sql.DB] {
+	return lazyconn.New("Database", connect, (*sql.DB).Close)
+}
+
+// DB returns the global database connection pool, connecting on first use. While Postgres is unreachable it
+// returns nil and retries with backoff (see lazyconn); once connected, database/sql replaces broken
+// connections itself.
 func DB(ctx context.Context) *sql.DB {
-	once.Do(func() {
-		cfg := config.Get()
-		if cfg.DatabaseURL == "" {
-			logger.Error(ctx, "DATABASE_URL is not set")
-			return
-		}
-		db, err := sql.Open("postgres", cfg.DatabaseURL)
-		if err != nil {
-			logger.Error(ctx, "Failed to open database", "error", err)
-			return
-		}
-		db.SetMaxOpenConns(cfg.DBPoolSize)
-		db.SetMaxIdleConns(cfg.DBPoolSize / 2)
-		pool = db
-		logger.Info(ctx, "Database pool initialized", "max_open", cfg.DBPoolSize)
-	})
-	return pool
+	return pool.Get(ctx)
 }
 
-// InitDB initializes the DB pool and returns it (for backward compatibility / main).
+// connect opens a pool and pings it.
+func connect(ctx context.Context) (*sql.DB, error) {
+	cfg := config.Get()
+	if cfg.DatabaseURL == "" {
+		return nil, errors.New("DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.DBPoolSize)
+	db.SetMaxIdleConns(cfg.DBPoolSize / 2)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	logger.Info(ctx, "Database pool initialized", "max_open", cfg.DBPoolSize)
+	return db, nil
+}
+
+// InitDB waits until the pool is connected or ctx ends (then returns nil). Used at startup, where nothing
+// can run without the database. Returns nil at once if DATABASE_URL is not set.
 func InitDB(ctx context.Context) *sql.DB {
-	return DB(ctx)
+	if config.Get().DatabaseURL == "" {
+		logger.Error(ctx, "DATABASE_URL is not set")
+		return nil
+	}
+	return pool.Wait(ctx)
 }
 
-// MigrateOrCreateSchema creates the todos table and indexes if they do not exist.
-func MigrateOrCreateSchema(ctx context.Context) error {
+// Ping checks that the database answers now, for readiness probes.
+func Ping(ctx context.Context) error {
 	db := DB(ctx)
 	if db == nil {
-		return nil
+		return ErrUnavailable
 	}
-	_, err := db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS todos (
-			id          TEXT PRIMARY KEY,
-			title       TEXT NOT NULL,
-			description TEXT,
-			completed   BOOLEAN NOT NULL DEFAULT FALSE,
-			user_id     TEXT NOT NULL,
-			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		);
-		CREATE INDEX IF NOT EXISTS idx_todos_user_id ON todos(user_id);
-		CREATE INDEX IF NOT EXISTS idx_todos_created_at ON todos(created_at DESC);
-	`)
-	if err != nil {
-		return err
-	}
-	logger.Info(ctx, "Schema ensured (todos table)")
-	return nil
+	return db.PingContext(ctx)
+}
+
+// Close closes the pool, if one was created, and stops further connection attempts.
+func Close() error {
+	return pool.Close()
 }
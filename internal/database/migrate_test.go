@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"million-rps/internal/config"
+)
+
+func TestMigrationsAreOrderedAndReversible(t *testing.T) {
+	list, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for i, m := range list {
+		if i > 0 && m.Version <= list[i-1].Version {
+			t.Errorf("migration %d_%s out of order", m.Version, m.Name)
+		}
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("migration %d_%s missing up or down SQL", m.Version, m.Name)
+		}
+	}
+}
+
+func TestDBBacksOffWhileUnreachable(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Get()
+	prev := cfg.DatabaseURL
+	cfg.DatabaseURL = "postgres://todo@127.0.0.1:1/todo?sslmode=disable&connect_timeout=1"
+	t.Cleanup(func() {
+		cfg.DatabaseURL, pool = prev, newPool()
+	})
+
+	if db := DB(ctx); db != nil {
+		t.Fatal("DB connected to an unreachable server")
+	}
+	first := pool.RetryAt()
+	if !time.Now().Before(first) {
+		t.Fatal("failed connect did not schedule a retry")
+	}
+	// Within the backoff window DB returns at once without another attempt.
+	if DB(ctx) != nil || !pool.RetryAt().Equal(first) {
+		t.Fatal("DB retried before the backoff elapsed")
+	}
+	if err := Ping(ctx); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Ping = %v, want ErrUnavailable", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if InitDB(waitCtx) != nil {
+		t.Fatal("InitDB returned a pool for an unreachable server")
+	}
+}
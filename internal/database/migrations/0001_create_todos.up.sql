@@ -1,4 +1,3 @@
--- Run this against your PostgreSQL database before starting the app.
 CREATE TABLE IF NOT EXISTS todos (
     id          TEXT PRIMARY KEY,
     title       TEXT NOT NULL,
@@ -0,0 +1,2 @@
+-- Optimistic concurrency: every applied update bumps version; writes may require an expected version.
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
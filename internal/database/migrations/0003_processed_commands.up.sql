@@ -0,0 +1,10 @@
+-- Idempotent worker: ids of commands already applied (or rejected) with their outcome, so redelivered
+-- Kafka messages are recognised instead of written twice. Pruned after PROCESSED_COMMANDS_RETENTION_HOURS.
+CREATE TABLE IF NOT EXISTS processed_commands (
+    command_id   TEXT PRIMARY KEY,
+    status       TEXT NOT NULL,
+    reason       TEXT NOT NULL DEFAULT '',
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_processed_commands_processed_at ON processed_commands(processed_at);
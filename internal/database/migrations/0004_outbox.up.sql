@@ -0,0 +1,12 @@
+-- Transactional outbox (OUTBOX_ENABLED): accepted write commands are stored here and relayed to Kafka.
+-- sent_at is set once the broker acknowledged the command.
+CREATE TABLE IF NOT EXISTS outbox (
+    id         BIGSERIAL PRIMARY KEY,
+    command_id TEXT NOT NULL UNIQUE,
+    payload    JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_unsent ON outbox(id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_sent_at ON outbox(sent_at) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,111 @@
+// Package lazyconn holds a client that is connected on first use. While its server is unreachable, callers
+// get nil and a new attempt is made at most once per backoff interval, so a dependency that comes back
+// later is picked up without a restart.
+package lazyconn
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"million-rps/pkg/logger"
+)
+
+const (
+	// Timeout bounds one connection attempt, including the ping that decides whether the client is usable.
+	Timeout = 2 * time.Second
+	// Failed attempts are retried after minRetry, doubling up to maxRetry.
+	minRetry = time.Second
+	maxRetry = 30 * time.Second
+)
+
+// Conn is a lazily connected client of type T. Create it with New.
+type Conn[T any] struct {
+	name  string
+	dial  func(ctx context.Context) (*T, error)
+	close func(*T) error
+
+	client atomic.Pointer[T]
+
+	mu      sync.Mutex // held while connecting or closing
+	retryAt time.Time
+	retryIn time.Duration
+	closed  bool
+}
+
+// New returns a Conn that connects with dial, which must return only a client it has pinged, and releases
+// it with close. name labels the log lines ("Redis", "Database").
+func New[T any](name string, dial func(ctx context.Context) (*T, error), close func(*T) error) *Conn[T] {
+	return &Conn[T]{name: name, dial: dial, close: close}
+}
+
+// Get returns the client, connecting on first use. While the server is unreachable it returns nil and
+// retries at most once per backoff interval (1s doubling to 30s). Once connected, the client is kept and
+// expected to replace broken connections itself.
+func (c *Conn[T]) Get(ctx context.Context) *T {
+	if v := c.client.Load(); v != nil {
+		return v
+	}
+	// Another caller is connecting; don't queue requests behind its ping.
+	if !c.mu.TryLock() {
+		return nil
+	}
+	defer c.mu.Unlock()
+	if v := c.client.Load(); v != nil || c.closed || time.Now().Before(c.retryAt) {
+		return v
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	v, err := c.dial(dialCtx)
+	if err != nil {
+		c.retryIn = min(max(c.retryIn*2, minRetry), maxRetry)
+		c.retryAt = time.Now().Add(c.retryIn)
+		logger.Error(ctx, c.name+" unavailable; will retry", "error", err, "retry_in", c.retryIn.String())
+		return nil
+	}
+	c.retryIn, c.retryAt = 0, time.Time{}
+	c.client.Store(v)
+	return v
+}
+
+// Wait blocks until the client is connected, or returns nil once ctx ends or the Conn is closed. For
+// startup paths that cannot run without the dependency.
+func (c *Conn[T]) Wait(ctx context.Context) *T {
+	for {
+		if v := c.Get(ctx); v != nil {
+			return v
+		}
+		c.mu.Lock()
+		wait, stop := time.Until(c.retryAt), c.closed
+		c.mu.Unlock()
+		if stop {
+			return nil
+		}
+		t := time.NewTimer(max(wait, 10*time.Millisecond))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		}
+	}
+}
+
+// RetryAt returns when the next connection attempt is due; zero while connected or before the first attempt.
+func (c *Conn[T]) RetryAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retryAt
+}
+
+// Close closes the client, if one was connected, and stops further connection attempts.
+func (c *Conn[T]) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if v := c.client.Swap(nil); v != nil {
+		return c.close(v)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package lazyconn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnBacksOffUntilDialSucceeds(t *testing.T) {
+	ctx := context.Background()
+	var dials int
+	up := false
+	closed := 0
+	c := New("Test", func(context.Context) (*int, error) {
+		dials++
+		if !up {
+			return nil, errors.New("refused")
+		}
+		v := dials
+		return &v, nil
+	}, func(*int) error { closed++; return nil })
+
+	if c.Get(ctx) != nil || dials != 1 {
+		t.Fatalf("first Get: dials = %d, want a failed attempt", dials)
+	}
+	first := c.RetryAt()
+	if !time.Now().Before(first) {
+		t.Fatal("failed dial did not schedule a retry")
+	}
+	// Within the backoff window Get returns at once without dialing.
+	if c.Get(ctx) != nil || dials != 1 || !c.RetryAt().Equal(first) {
+		t.Fatalf("Get dialed again before the backoff elapsed (dials = %d)", dials)
+	}
+
+	up = true
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	v := c.Wait(waitCtx)
+	if v == nil || dials != 2 || !c.RetryAt().IsZero() {
+		t.Fatalf("Wait = %v after %d dials, retryAt %v; want the second dial's client", v, dials, c.RetryAt())
+	}
+	if c.Get(ctx) != v || dials != 2 {
+		t.Fatal("Get did not reuse the connected client")
+	}
+
+	if err := c.Close(); err != nil || closed != 1 {
+		t.Fatalf("Close = %v, closed %d clients", err, closed)
+	}
+	if c.Get(ctx) != nil || c.Wait(ctx) != nil || dials != 2 {
+		t.Fatal("closed Conn connected again")
+	}
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+const namespace = "million_rps"
+
+var (
+	// HTTPRequests counts requests per route template (e.g. /todos/:id), method and status.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPDuration observes request latency per route and method.
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"route", "method"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Redis cache lookups by result (hit, miss).",
+	}, []string{"result"})
+	cacheHits   = cacheLookups.WithLabelValues("hit")
+	cacheMisses = cacheLookups.WithLabelValues("miss")
+
+	l1Lookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_l1_lookups_total",
+		Help:      "In-process L1 cache lookups by result (hit, miss); misses go on to Redis.",
+	}, []string{"result"})
+	l1Hits   = l1Lookups.WithLabelValues("hit")
+	l1Misses = l1Lookups.WithLabelValues("miss")
+
+	singleflightCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "singleflight_calls_total",
+		Help:      "Cache-miss loads through singleflight by whether the result was shared with other callers.",
+	}, []string{"shared"})
+
+	kafkaPublish = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_publish_messages_total",
+		Help:      "Todo command messages handed to Kafka by delivery result (ok, error).",
+	}, []string{"result"})
+
+	workerMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "worker_messages_total",
+		Help:      "Messages settled by the worker by outcome (applied, failed) and partition.",
+	}, []string{"outcome", "partition"})
+
+	workerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "worker_partition_lag",
+		Help:      "Messages behind the partition high-water mark as of the last fetched message.",
+	}, []string{"partition"})
+)
+
+// Handler returns the Prometheus scrape handler.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// CacheLookup records a cache hit or miss.
+func CacheLookup(hit bool) {
+	if hit {
+		cacheHits.Inc()
+		return
+	}
+	cacheMisses.Inc()
+}
+
+// CacheL1Lookup records an in-process L1 hit or miss.
+func CacheL1Lookup(hit bool) {
+	if hit {
+		l1Hits.Inc()
+		return
+	}
+	l1Misses.Inc()
+}
+
+// SingleflightCall records one singleflight.Do result.
+func SingleflightCall(shared bool) {
+	singleflightCalls.WithLabelValues(strconv.FormatBool(shared)).Inc()
+}
+
+// KafkaPublished records n messages delivered (err == nil) or failed.
+func KafkaPublished(n int, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	kafkaPublish.WithLabelValues(result).Add(float64(n))
+}
+
+// OutcomeDuplicate is the WorkerMessage outcome for a redelivered command that was already processed.
+const OutcomeDuplicate = "duplicate"
+
+// WorkerMessage records a settled message and the partition lag observed when it was fetched.
+func WorkerMessage(outcome string, partition int, lag int64) {
+	p := strconv.Itoa(partition)
+	workerMessages.WithLabelValues(outcome, p).Inc()
+	if lag < 0 {
+		lag = 0
+	}
+	workerLag.WithLabelValues(p).Set(float64(lag))
+}
+
+// RegisterDBStats exports sql.DB pool stats. pool is called on every scrape and may return nil while the pool is down.
+func RegisterDBStats(pool func() *sql.DB) {
+	stat := func(f func(sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			db := pool()
+			if db == nil {
+				return 0
+			}
+			return f(db.Stats())
+		}
+	}
+	gauge := func(name, help string, f func(sql.DBStats) float64) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Subsystem: "db", Name: name, Help: help}, stat(f))
+	}
+	counter := func(name, help string, f func(sql.DBStats) float64) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{Namespace: namespace, Subsystem: "db", Name: name, Help: help}, stat(f))
+	}
+	gauge("open_connections", "Established connections, in use and idle.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("in_use_connections", "Connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("idle_connections", "Idle connections.", func(s sql.DBStats) float64 { return float64(s.Idle) })
+	gauge("max_open_connections", "Configured pool size.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	counter("wait_count_total", "Connections waited for.", func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("wait_duration_seconds_total", "Time blocked waiting for a connection.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+}
+
+// RegisterRedisPoolStats exports go-redis pool stats. client is called on every scrape and may return nil.
+func RegisterRedisPoolStats(client func() *redis.Client) {
+	stat := func(f func(*redis.PoolStats) float64) func() float64 {
+		return func() float64 {
+			c := client()
+			if c == nil {
+				return 0
+			}
+			return f(c.PoolStats())
+		}
+	}
+	gauge := func(name, help string, f func(*redis.PoolStats) float64) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Subsystem: "redis_pool", Name: name, Help: help}, stat(f))
+	}
+	counter := func(name, help string, f func(*redis.PoolStats) float64) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{Namespace: namespace, Subsystem: "redis_pool", Name: name, Help: help}, stat(f))
+	}
+	gauge("total_connections", "Connections in the pool.", func(s *redis.PoolStats) float64 { return float64(s.TotalConns) })
+	gauge("idle_connections", "Idle connections in the pool.", func(s *redis.PoolStats) float64 { return float64(s.IdleConns) })
+	counter("hits_total", "Times a free connection was found in the pool.", func(s *redis.PoolStats) float64 { return float64(s.Hits) })
+	counter("misses_total", "Times a free connection was not found in the pool.", func(s *redis.PoolStats) float64 { return float64(s.Misses) })
+	counter("timeouts_total", "Times a wait for a connection timed out.", func(s *redis.PoolStats) float64 { return float64(s.Timeouts) })
+}
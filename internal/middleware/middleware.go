@@ -2,9 +2,12 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"million-rps/internal/config"
+	"million-rps/internal/metrics"
 	"million-rps/pkg/logger"
 
 	"github.com/gin-gonic/gin"
@@ -47,3 +50,19 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// Metrics records request count and latency per route template. Unmatched routes are grouped under "unmatched"
+// so random paths cannot blow up label cardinality.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		metrics.HTTPRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	}
+}
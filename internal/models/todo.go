@@ -11,15 +11,45 @@ type Todo struct {
 	UserID      string    `json:"user_id"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int64     `json:"version"`
+}
+
+// TodoPage is one keyset-paginated page of todos. NextCursor is empty on the last page.
+type TodoPage struct {
+	Items      []Todo `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // TodoCommand is the message payload for Kafka (create/update/delete).
 type TodoCommand struct {
-	Action      string    `json:"action"` // create, update, delete
-	ID          string    `json:"id"`
-	Title       string    `json:"title,omitempty"`
-	Description string    `json:"description,omitempty"`
-	Completed   *bool     `json:"completed,omitempty"`
-	UserID      string    `json:"user_id"`
-	RequestedAt time.Time `json:"requested_at"`
+	CommandID   string `json:"command_id"`
+	Action      string `json:"action"` // create, update, delete
+	ID          string `json:"id"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Completed   *bool  `json:"completed,omitempty"`
+	UserID      string `json:"user_id"`
+	// ExpectedVersion (from If-Match) makes update/delete apply only if the todo is still at that version.
+	ExpectedVersion *int64    `json:"expected_version,omitempty"`
+	RequestedAt     time.Time `json:"requested_at"`
+}
+
+// Command outcome states recorded in CommandStatus.
+const (
+	CommandPending  = "pending"
+	CommandApplied  = "applied"
+	CommandFailed   = "failed"
+	CommandRejected = "rejected" // not applied because the target is unknown, foreign, or nothing changed
+	CommandConflict = "conflict" // not applied because ExpectedVersion was stale
+)
+
+// CommandStatus is the outcome of a TodoCommand as recorded by the API (pending) and the worker (applied/failed/rejected/conflict).
+type CommandStatus struct {
+	CommandID string    `json:"command_id"`
+	Action    string    `json:"action"`
+	TodoID    string    `json:"todo_id"`
+	UserID    string    `json:"user_id"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
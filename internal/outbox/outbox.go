@@ -0,0 +1,67 @@
+// Package outbox implements the transactional outbox write path (OUTBOX_ENABLED): handlers store accepted
+// commands in Postgres instead of publishing them, and Relay forwards them to the queue.
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/internal/models"
+	"million-rps/internal/queue"
+	"million-rps/internal/repository"
+	"million-rps/pkg/logger"
+)
+
+// maxRelayBackoff caps the wait between failed relay attempts (broker or DB down).
+const maxRelayBackoff = 5 * time.Second
+
+// Publisher implements queue.CommandPublisher by enqueueing commands in the outbox table, so accepting a
+// write only depends on Postgres.
+type Publisher struct {
+	Store repository.TodoStore
+}
+
+var _ queue.CommandPublisher = Publisher{}
+
+func (p Publisher) PublishTodoCommand(ctx context.Context, cmd *models.TodoCommand) error {
+	return p.Store.EnqueueCommand(ctx, cmd)
+}
+
+// Relay publishes unsent outbox commands to pub, oldest first, until ctx is cancelled. It polls every
+// OUTBOX_POLL_MS while the outbox is drained, backs off while pub or the store fail, and prunes rows sent
+// more than OUTBOX_RETENTION_HOURS ago once an hour. Safe to run on every replica: only one relays at a time.
+func Relay(ctx context.Context, store repository.TodoStore, pub queue.BatchPublisher) {
+	cfg := config.Get()
+	poll := time.Duration(max(cfg.OutboxPollInterval, 1)) * time.Millisecond
+	limit := max(cfg.OutboxBatchSize, 1)
+	retention := time.Duration(cfg.OutboxRetention) * time.Hour
+	logger.Info(ctx, "Outbox relay started", "batch", limit, "poll_ms", cfg.OutboxPollInterval)
+
+	var lastPrune time.Time
+	wait := poll
+	for {
+		n, err := store.RelayOutbox(ctx, limit, pub.PublishTodoCommands)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logger.Error(ctx, "Outbox relay failed; retrying", "error", err, "backoff", wait)
+			wait = min(max(wait*2, poll), maxRelayBackoff)
+		case n == limit:
+			// More rows are probably waiting: relay the next batch right away.
+			wait = 0
+		default:
+			wait = poll
+		}
+		if retention > 0 && time.Since(lastPrune) > time.Hour {
+			lastPrune = time.Now()
+			if pruned, err := store.PruneOutbox(ctx, retention); err == nil && pruned > 0 {
+				logger.Info(ctx, "Pruned outbox", "rows", pruned)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
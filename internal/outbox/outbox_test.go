@@ -0,0 +1,80 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/internal/models"
+	"million-rps/internal/queue"
+	"million-rps/internal/repository"
+)
+
+// flakyBroker fails every publish while down is set.
+type flakyBroker struct {
+	queue.Memory
+	down atomic.Bool
+}
+
+func (b *flakyBroker) PublishTodoCommands(ctx context.Context, cmds []*models.TodoCommand) error {
+	if b.down.Load() {
+		return errors.New("broker down")
+	}
+	return b.Memory.PublishTodoCommands(ctx, cmds)
+}
+
+func TestRelayPublishesInOrderAndMarksSent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := config.Get()
+	prevBatch, prevPoll := cfg.OutboxBatchSize, cfg.OutboxPollInterval
+	cfg.OutboxBatchSize, cfg.OutboxPollInterval = 3, 1
+	t.Cleanup(func() { cfg.OutboxBatchSize, cfg.OutboxPollInterval = prevBatch, prevPoll })
+
+	store := repository.NewMemory()
+	p := Publisher{Store: store}
+	for i := 0; i < 7; i++ {
+		if err := p.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: fmt.Sprintf("c%d", i), Action: "create", ID: "t"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Enqueueing the same command twice is a no-op.
+	_ = p.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: "c0", Action: "create", ID: "t"})
+
+	broker := &flakyBroker{}
+	broker.down.Store(true)
+	done := make(chan struct{})
+	go func() {
+		Relay(ctx, store, broker)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if store.Unsent() != 7 {
+		t.Fatalf("%d unsent while the broker is down, want 7", store.Unsent())
+	}
+
+	broker.down.Store(false)
+	deadline := time.Now().Add(10 * time.Second)
+	for store.Unsent() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d commands still unsent", store.Unsent())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	got := broker.Drain()
+	if len(got) != 7 {
+		t.Fatalf("relayed %d commands, want 7", len(got))
+	}
+	for i, cmd := range got {
+		if want := fmt.Sprintf("c%d", i); cmd.CommandID != want {
+			t.Fatalf("command %d = %s, want %s (in enqueue order)", i, cmd.CommandID, want)
+		}
+	}
+}
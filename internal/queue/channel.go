@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"million-rps/internal/models"
+	"million-rps/pkg/logger"
+)
+
+// ErrQueueFull is returned when the in-process queue stays full until the publish context ends.
+var ErrQueueFull = errors.New("command queue full")
+
+// ErrQueueClosed is returned when publishing to an in-process queue after Flush or Close.
+var ErrQueueClosed = errors.New("command queue closed")
+
+// channelTopic names the single "partition" of the in-process queue in delivered messages.
+const channelTopic = "memory"
+
+// maxDeadLetters caps the dead letters a Channel keeps in memory; older ones are dropped (they stay in the log).
+const maxDeadLetters = 1000
+
+// Channel is an in-process CommandQueue backed by a buffered channel. Publishing blocks while the buffer is
+// full (the API answers 503 once the request context ends), and nothing survives a restart, so it suits
+// single-node deployments and local development. Messages are delivered as one partition with increasing
+// offsets, so the worker keeps the same per-key ordering as with Kafka.
+type Channel struct {
+	ch   chan Message
+	done chan struct{} // closed by Flush or Close; publishes fail from then on
+
+	mu      sync.Mutex
+	next    int64     // offset of the next message fetched
+	dead    []Message // the last maxDeadLetters dead-lettered messages, newest last
+	flushed bool
+	closed  bool
+}
+
+var _ CommandQueue = (*Channel)(nil)
+
+// NewChannel returns an in-process queue buffering up to size commands.
+func NewChannel(size int) *Channel {
+	return &Channel{ch: make(chan Message, max(size, 1)), done: make(chan struct{})}
+}
+
+func (c *Channel) PublishTodoCommand(ctx context.Context, cmd *models.TodoCommand) error {
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-c.done:
+		return ErrQueueClosed
+	default:
+	}
+	select {
+	case c.ch <- Message{Topic: channelTopic, Key: []byte(PartitionKey(cmd)), Value: payload}:
+		return nil
+	case <-c.done:
+		return ErrQueueClosed
+	case <-ctx.Done():
+		return ErrQueueFull
+	}
+}
+
+func (c *Channel) PublishTodoCommands(ctx context.Context, cmds []*models.TodoCommand) error {
+	for _, cmd := range cmds {
+		if err := c.PublishTodoCommand(ctx, cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Consumer returns a consumer over the channel. Every consumer competes for the same messages.
+func (c *Channel) Consumer(ctx context.Context) (Consumer, error) { return channelConsumer{c}, nil }
+
+// channelConsumer is the worker's view of a Channel; closing it leaves the queue open.
+type channelConsumer struct {
+	*Channel
+}
+
+func (channelConsumer) Close() error { return nil }
+
+func (c *Channel) FetchMessage(ctx context.Context) (Message, error) {
+	select {
+	case msg := <-c.ch:
+		c.mu.Lock()
+		msg.Offset = c.next
+		c.next++
+		c.mu.Unlock()
+		msg.HighWaterMark = msg.Offset + int64(len(c.ch)) + 1
+		return msg, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// CommitMessages is a no-op: a fetched message has already left the channel.
+func (c *Channel) CommitMessages(ctx context.Context, msgs ...Message) error { return nil }
+
+// PublishDeadLetter logs msg and keeps it in memory (see DeadLetters), dropping the oldest beyond maxDeadLetters.
+func (c *Channel) PublishDeadLetter(ctx context.Context, msg Message, cause error, attempts int) error {
+	logger.Error(ctx, "In-process queue dead-lettered command", "error", cause, "attempts", attempts, "payload", string(msg.Value))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.dead) >= maxDeadLetters {
+		c.dead = append(c.dead[:0], c.dead[len(c.dead)-maxDeadLetters+1:]...)
+	}
+	c.dead = append(c.dead, msg)
+	return nil
+}
+
+// DeadLetters returns the most recent dead-lettered messages, oldest first.
+func (c *Channel) DeadLetters() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.dead...)
+}
+
+// Healthy always succeeds: publishing only fails when the buffer is full, which callers see directly.
+func (c *Channel) Healthy() error { return nil }
+
+// Flush stops accepting publishes; later ones fail with ErrQueueClosed. Commands already queued stay in the
+// channel for the worker, which keeps consuming until it is stopped.
+func (c *Channel) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopPublishing()
+	return nil
+}
+
+// stopPublishing closes done once; the caller holds mu.
+func (c *Channel) stopPublishing() {
+	if !c.flushed {
+		close(c.done)
+		c.flushed = true
+	}
+}
+
+// Close reports how many commands were still queued; they are dropped. Like Flush, it makes later publishes
+// fail with ErrQueueClosed.
+func (c *Channel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed && len(c.ch) > 0 {
+		logger.Warn(context.Background(), "In-process queue closed with unapplied commands", "count", len(c.ch))
+	}
+	c.stopPublishing()
+	c.closed = true
+	return nil
+}
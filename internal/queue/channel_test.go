@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/internal/models"
+)
+
+func TestChannelDeliversInOrderWithOffsets(t *testing.T) {
+	ctx := context.Background()
+	q := NewChannel(4)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := q.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: id, Action: "create", ID: "t-" + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, _ := q.Consumer(ctx)
+	defer c.Close()
+	for i, want := range []string{"a", "b", "c"} {
+		msg, err := c.FetchMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cmd models.TodoCommand
+		if err := json.Unmarshal(msg.Value, &cmd); err != nil || cmd.CommandID != want {
+			t.Fatalf("message %d = %s (%v), want %s", i, msg.Value, err, want)
+		}
+		if msg.Offset != int64(i) || string(msg.Key) != "t-"+want {
+			t.Fatalf("message %d offset/key = %d/%s", i, msg.Offset, msg.Key)
+		}
+		if lag := msg.HighWaterMark - msg.Offset - 1; lag != int64(2-i) {
+			t.Fatalf("message %d lag = %d, want %d", i, lag, 2-i)
+		}
+	}
+}
+
+func TestChannelFullReturnsErrQueueFull(t *testing.T) {
+	q := NewChannel(1)
+	_ = q.PublishTodoCommand(context.Background(), &models.TodoCommand{CommandID: "a"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: "b"}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("publish to full queue err = %v, want ErrQueueFull", err)
+	}
+}
+
+func TestChannelRejectsPublishesAfterClose(t *testing.T) {
+	ctx := context.Background()
+	q := NewChannel(1)
+	_ = q.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: "a"})
+	blocked := make(chan error, 1)
+	go func() { blocked <- q.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: "b"}) }()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	if err := <-blocked; !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("publish blocked across Close err = %v, want ErrQueueClosed", err)
+	}
+	if err := q.PublishTodoCommands(ctx, []*models.TodoCommand{{CommandID: "c"}}); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("publish after Close err = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestChannelFlushStopsPublishesButKeepsQueuedCommands(t *testing.T) {
+	ctx := context.Background()
+	q := NewChannel(2)
+	if err := q.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: "b"}); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("publish after Flush err = %v, want ErrQueueClosed", err)
+	}
+	if _, err := q.FetchMessage(ctx); err != nil {
+		t.Fatalf("command queued before Flush was not delivered: %v", err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChannelKeepsOnlyRecentDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	q := NewChannel(1)
+	for i := range maxDeadLetters + 5 {
+		_ = q.PublishDeadLetter(ctx, Message{Offset: int64(i)}, errors.New("bad"), 1)
+	}
+	dead := q.DeadLetters()
+	if len(dead) != maxDeadLetters || dead[0].Offset != 5 || dead[len(dead)-1].Offset != maxDeadLetters+4 {
+		t.Fatalf("kept %d dead letters, offsets %d..%d", len(dead), dead[0].Offset, dead[len(dead)-1].Offset)
+	}
+}
+
+func TestOpenRejectsUnknownBackend(t *testing.T) {
+	cfg := config.Get()
+	prev := cfg.QueueBackend
+	t.Cleanup(func() { cfg.QueueBackend = prev })
+	cfg.QueueBackend = "carrier-pigeon"
+	if _, err := Open(context.Background()); err == nil {
+		t.Fatal("Open accepted an unknown backend")
+	}
+	cfg.QueueBackend = "memory"
+	if q, err := Open(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if _, ok := q.(*Channel); !ok {
+		t.Fatalf("memory backend = %T, want *Channel", q)
+	}
+}
@@ -0,0 +1,163 @@
+package queue
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/pkg/logger"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// dialTimeout bounds connecting (including TLS and SASL handshakes) to one broker.
+const dialTimeout = 10 * time.Second
+
+var (
+	connOnce  sync.Once
+	transport *kafka.Transport
+	dialer    *kafka.Dialer
+)
+
+// Brokers returns the KAFKA_BROKERS list (comma-separated host:port, blanks ignored).
+func Brokers() []string {
+	var out []string
+	for _, b := range strings.Split(config.Get().KafkaBrokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// Transport returns the connection settings shared by every Kafka writer: client id, TLS and SASL.
+func Transport() *kafka.Transport {
+	initConn()
+	return transport
+}
+
+// Dialer returns the connection settings for readers and admin connections, matching Transport.
+func Dialer() *kafka.Dialer {
+	initConn()
+	return dialer
+}
+
+func initConn() {
+	connOnce.Do(func() {
+		cfg := config.Get()
+		tlsCfg, mech, err := security()
+		if err != nil {
+			// Open rejects this configuration before any client is built; log in case a tool skipped it.
+			logger.Error(context.Background(), "Invalid Kafka security settings; connecting without them", "error", err)
+		}
+		transport = &kafka.Transport{
+			DialTimeout: dialTimeout,
+			ClientID:    cfg.KafkaClientID,
+			TLS:         tlsCfg,
+			SASL:        mech,
+		}
+		dialer = &kafka.Dialer{
+			Timeout:       dialTimeout,
+			DualStack:     true,
+			ClientID:      cfg.KafkaClientID,
+			TLS:           tlsCfg,
+			SASLMechanism: mech,
+		}
+	})
+}
+
+// security builds the TLS config (nil unless KAFKA_TLS) and SASL mechanism (nil unless KAFKA_SASL_MECHANISM)
+// from the environment.
+func security() (*tls.Config, sasl.Mechanism, error) {
+	cfg := config.Get()
+	var tlsCfg *tls.Config
+	if cfg.KafkaTLS {
+		tlsCfg = &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cfg.KafkaTLSSkipVerify}
+		if cfg.KafkaTLSCAFile != "" {
+			pem, err := os.ReadFile(cfg.KafkaTLSCAFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("KAFKA_TLS_CA_FILE: %w", err)
+			}
+			tlsCfg.RootCAs = x509.NewCertPool()
+			if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, nil, fmt.Errorf("KAFKA_TLS_CA_FILE %s: no PEM certificates", cfg.KafkaTLSCAFile)
+			}
+		}
+	}
+	mech, err := saslMechanism(cfg.KafkaSASLMechanism, cfg.KafkaSASLUsername, cfg.KafkaSASLPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tlsCfg, mech, nil
+}
+
+func saslMechanism(name, user, pass string) (sasl.Mechanism, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: user, Password: pass}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, user, pass)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, user, pass)
+	default:
+		return nil, fmt.Errorf("unknown KAFKA_SASL_MECHANISM %q (want plain, scram-sha-256 or scram-sha-512)", name)
+	}
+}
+
+// compression returns the producer codec for KAFKA_COMPRESSION; 0 means uncompressed.
+func compression(name string) (kafka.Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("unknown KAFKA_COMPRESSION %q (want none, gzip, snappy, lz4 or zstd)", name)
+	}
+}
+
+// checkKafkaConfig validates the Kafka settings Open depends on, so a typo fails startup instead of every publish.
+func checkKafkaConfig() error {
+	if len(Brokers()) == 0 {
+		return errors.New("QUEUE_BACKEND=kafka requires KAFKA_BROKERS")
+	}
+	if _, err := compression(config.Get().KafkaCompression); err != nil {
+		return err
+	}
+	_, _, err := security()
+	return err
+}
+
+// dialAny connects to the first reachable broker in Brokers().
+func dialAny(ctx context.Context) (*kafka.Conn, error) {
+	var errs []error
+	for _, b := range Brokers() {
+		conn, err := Dialer().DialContext(ctx, "tcp", b)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", b, err))
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no Kafka brokers configured")
+	}
+	return nil, errors.Join(errs...)
+}
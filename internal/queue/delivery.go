@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"million-rps/internal/cache"
+	"million-rps/internal/metrics"
+	"million-rps/internal/models"
+	"million-rps/pkg/logger"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// unhealthyWindow is how long a delivery failure (with no success since) keeps the producer reported unhealthy.
+const unhealthyWindow = 30 * time.Second
+
+// ErrProducerFailing is returned by ProducerHealthy while recent deliveries are failing.
+var ErrProducerFailing = errors.New("kafka producer deliveries failing")
+
+var (
+	delivered     atomic.Int64
+	failed        atomic.Int64
+	lastSuccessNs atomic.Int64
+	lastFailureNs atomic.Int64
+	lastError     atomic.Value // string
+)
+
+// DeliveryStats is a snapshot of producer delivery outcomes since process start.
+type DeliveryStats struct {
+	Delivered   int64     `json:"delivered"`
+	Failed      int64     `json:"failed"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitempty"`
+}
+
+// Stats returns the current delivery counters.
+func Stats() DeliveryStats {
+	s := DeliveryStats{Delivered: delivered.Load(), Failed: failed.Load()}
+	if v, ok := lastError.Load().(string); ok {
+		s.LastError = v
+	}
+	if ns := lastFailureNs.Load(); ns > 0 {
+		s.LastFailure = time.Unix(0, ns)
+	}
+	return s
+}
+
+// ProducerHealthy returns ErrProducerFailing if the most recent delivery outcome was a failure within
+// unhealthyWindow. Used by the readiness probe so pods that cannot reach Kafka stop taking writes.
+func ProducerHealthy() error {
+	lf := lastFailureNs.Load()
+	if lf == 0 || lf < lastSuccessNs.Load() || time.Since(time.Unix(0, lf)) > unhealthyWindow {
+		return nil
+	}
+	return ErrProducerFailing
+}
+
+// onCompletion is the async writer's Completion callback: it counts outcomes and, on failure, logs and marks
+// each affected command failed so clients polling GET /commands/:id learn the write was dropped. Messages
+// whose publisher is waiting (WriterData holds its channel) get the outcome handed back instead.
+func onCompletion(messages []kafka.Message, err error) {
+	recordDelivery(len(messages), err)
+	dropped := 0
+	for _, m := range messages {
+		if done, ok := m.WriterData.(chan error); ok {
+			done <- err
+			continue
+		}
+		dropped++
+	}
+	if err == nil || dropped == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	logger.Error(ctx, "Kafka async delivery failed", "error", err, "messages", dropped)
+	for _, m := range messages {
+		if m.WriterData != nil {
+			continue
+		}
+		var cmd models.TodoCommand
+		if json.Unmarshal(m.Value, &cmd) != nil {
+			continue
+		}
+		cache.SetCommandOutcome(ctx, &cmd, models.CommandFailed, "publish failed: "+err.Error())
+	}
+}
+
+func recordDelivery(n int, err error) {
+	metrics.KafkaPublished(n, err)
+	now := time.Now().UnixNano()
+	if err != nil {
+		failed.Add(int64(n))
+		lastFailureNs.Store(now)
+		lastError.Store(err.Error())
+		return
+	}
+	delivered.Add(int64(n))
+	lastSuccessNs.Store(now)
+}
@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/pkg/logger"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers attached to dead-lettered messages. The original key and payload are kept unchanged.
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderError             = "x-error"
+	HeaderAttempts          = "x-attempts"
+	HeaderFailedAt          = "x-failed-at"
+)
+
+var (
+	dlqWriter *kafka.Writer
+	dlqOnce   sync.Once
+)
+
+// DLQProducer returns the dead-letter writer (initialized on first use). Unlike Producer it is synchronous:
+// the worker only commits a poison message once the broker has acknowledged its dead-letter copy.
+func DLQProducer(ctx context.Context) *kafka.Writer {
+	dlqOnce.Do(func() {
+		cfg := config.Get()
+		if len(Brokers()) == 0 || cfg.KafkaDLQTopic == "" {
+			return
+		}
+		dlqWriter = &kafka.Writer{
+			Addr:         kafka.TCP(Brokers()...),
+			Transport:    Transport(),
+			Topic:        cfg.KafkaDLQTopic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 10 * time.Millisecond,
+			RequiredAcks: kafka.RequireAll,
+		}
+		logger.Info(ctx, "Kafka DLQ producer initialized", "topic", cfg.KafkaDLQTopic)
+	})
+	return dlqWriter
+}
+
+// PublishDeadLetter copies msg to the dead-letter topic with the failure reason, source position and attempt count as headers.
+func PublishDeadLetter(ctx context.Context, msg kafka.Message, cause error, attempts int) error {
+	w := DLQProducer(ctx)
+	if w == nil {
+		return nil
+	}
+	reason := ""
+	if cause != nil {
+		reason = cause.Error()
+	}
+	return w.WriteMessages(ctx, kafka.Message{
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: []kafka.Header{
+			{Key: HeaderOriginalTopic, Value: []byte(msg.Topic)},
+			{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+			{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+			{Key: HeaderError, Value: []byte(reason)},
+			{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+			{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+		},
+	})
+}
+
+// CloseDLQ closes the dead-letter writer. Call after the worker has stopped.
+func CloseDLQ() error {
+	if dlqWriter == nil {
+		return nil
+	}
+	return dlqWriter.Close()
+}
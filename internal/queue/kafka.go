@@ -3,8 +3,11 @@ package queue
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"million-rps/internal/config"
 	"million-rps/internal/models"
@@ -13,14 +16,14 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-// EnsureTopic creates the todo-commands topic with configured partitions (idempotent).
+// EnsureTopic creates the todo-commands topic with configured partitions, plus the dead-letter topic (idempotent).
 // Call at startup; if it fails (e.g. no broker or topic exists), app still runs.
 func EnsureTopic(ctx context.Context) {
 	cfg := config.Get()
-	if cfg.KafkaBrokers == "" {
+	if len(Brokers()) == 0 {
 		return
 	}
-	conn, err := kafka.Dial("tcp", cfg.KafkaBrokers)
+	conn, err := dialAny(ctx)
 	if err != nil {
 		logger.Debug(ctx, "Kafka dial for topic creation failed", "error", err)
 		return
@@ -31,22 +34,30 @@ func EnsureTopic(ctx context.Context) {
 		logger.Debug(ctx, "Kafka controller lookup failed", "error", err)
 		return
 	}
-	ctrlConn, err := kafka.Dial("tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
+	ctrlConn, err := Dialer().DialContext(ctx, "tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
 	if err != nil {
 		logger.Debug(ctx, "Kafka controller dial failed", "error", err)
 		return
 	}
 	defer ctrlConn.Close()
-	err = ctrlConn.CreateTopics(kafka.TopicConfig{
+	topics := []kafka.TopicConfig{{
 		Topic:             cfg.KafkaTopic,
 		NumPartitions:     cfg.KafkaPartitions,
-		ReplicationFactor: 1,
-	})
+		ReplicationFactor: cfg.KafkaReplication,
+	}}
+	if cfg.KafkaDLQTopic != "" {
+		topics = append(topics, kafka.TopicConfig{
+			Topic:             cfg.KafkaDLQTopic,
+			NumPartitions:     1,
+			ReplicationFactor: cfg.KafkaReplication,
+		})
+	}
+	err = ctrlConn.CreateTopics(topics...)
 	if err != nil {
 		logger.Debug(ctx, "Kafka create topic failed (topic may already exist)", "error", err)
 		return
 	}
-	logger.Info(ctx, "Kafka topic ensured", "topic", cfg.KafkaTopic, "partitions", cfg.KafkaPartitions)
+	logger.Info(ctx, "Kafka topic ensured", "topic", cfg.KafkaTopic, "partitions", cfg.KafkaPartitions, "replication", cfg.KafkaReplication, "dlq", cfg.KafkaDLQTopic)
 }
 
 var (
@@ -54,25 +65,42 @@ var (
 	wOnce  sync.Once
 )
 
+// syncBatchTimeout bounds how long a publish that waits for its ack sits in a partial batch.
+const syncBatchTimeout = 5 * time.Millisecond
+
 // Producer returns the global Kafka writer for todo commands (initialized on first use).
+// It is async for throughput; delivery outcomes are reported through onCompletion. Messages are hashed
+// by key (see PartitionKey) so every command for one todo lands on the same partition. Publishes that
+// must wait for their ack (KAFKA_SYNC_ACTIONS, the outbox relay) use this same writer rather than a second
+// one, so a waited-for command can never overtake an earlier async command for the same key; when either
+// is configured the writer requires acks from all in-sync replicas and flushes partial batches quickly.
 func Producer(ctx context.Context) *kafka.Writer {
 	wOnce.Do(func() {
 		cfg := config.Get()
+		codec, _ := compression(cfg.KafkaCompression)
+		acks, batchTimeout := requiredAcks(cfg.KafkaRequiredAcks), time.Duration(0)
+		if len(cfg.KafkaSyncActions) > 0 || cfg.OutboxEnabled {
+			acks, batchTimeout = kafka.RequireAll, syncBatchTimeout
+		}
 		writer = &kafka.Writer{
-			Addr:         kafka.TCP(cfg.KafkaBrokers),
+			Addr:         kafka.TCP(Brokers()...),
+			Transport:    Transport(),
+			Compression:  codec,
 			Topic:        cfg.KafkaTopic,
-			Balancer:     &kafka.LeastBytes{},
+			Balancer:     &kafka.Hash{},
 			BatchSize:    100,
-			BatchTimeout: 0,
+			BatchTimeout: batchTimeout,
 			Async:        true,
-			RequiredAcks: kafka.RequireOne,
+			RequiredAcks: acks,
+			Completion:   onCompletion,
 		}
-		logger.Info(ctx, "Kafka producer initialized", "topic", cfg.KafkaTopic, "brokers", cfg.KafkaBrokers)
+		logger.Info(ctx, "Kafka producer initialized", "topic", cfg.KafkaTopic, "brokers", Brokers(), "acks", acks, "sync_actions", cfg.KafkaSyncActions, "compression", cfg.KafkaCompression)
 	})
 	return writer
 }
 
-// PublishTodoCommand publishes a todo command to Kafka. Non-blocking when using Async writer.
+// PublishTodoCommand publishes a todo command to Kafka. Non-blocking by default; actions configured in
+// KAFKA_SYNC_ACTIONS wait for the broker ack and return its error.
 func PublishTodoCommand(ctx context.Context, cmd *models.TodoCommand) error {
 	w := Producer(ctx)
 	if w == nil {
@@ -82,11 +110,99 @@ func PublishTodoCommand(ctx context.Context, cmd *models.TodoCommand) error {
 	if err != nil {
 		return err
 	}
-	key := []byte(cmd.UserID + ":" + cmd.Action)
-	return w.WriteMessages(ctx, kafka.Message{
-		Key:   key,
-		Value: payload,
-	})
+	msg := kafka.Message{Key: []byte(PartitionKey(cmd)), Value: payload}
+	var done chan error
+	if isSyncAction(cmd.Action) {
+		done = make(chan error, 1)
+		msg.WriterData = done
+	}
+	if err := w.WriteMessages(ctx, msg); err != nil {
+		recordDelivery(1, err)
+		return err
+	}
+	if done == nil {
+		return nil
+	}
+	return awaitDelivery(ctx, done)
+}
+
+// PublishTodoCommands publishes cmds and returns once the broker acknowledged all of them (acks=all with
+// the outbox enabled). Used by the outbox relay, which must not mark commands sent before that.
+func PublishTodoCommands(ctx context.Context, cmds []*models.TodoCommand) error {
+	w := Producer(ctx)
+	if w == nil {
+		return nil
+	}
+	msgs := make([]kafka.Message, 0, len(cmds))
+	waits := make([]chan error, 0, len(cmds))
+	for _, cmd := range cmds {
+		payload, err := json.Marshal(cmd)
+		if err != nil {
+			return err
+		}
+		done := make(chan error, 1)
+		msgs = append(msgs, kafka.Message{Key: []byte(PartitionKey(cmd)), Value: payload, WriterData: done})
+		waits = append(waits, done)
+	}
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		recordDelivery(len(msgs), err)
+		return err
+	}
+	for _, done := range waits {
+		if err := awaitDelivery(ctx, done); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awaitDelivery waits for onCompletion to report the outcome of the message carrying done as WriterData.
+func awaitDelivery(ctx context.Context, done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PartitionKey returns the Kafka message key for cmd. Commands sharing a key go to the same partition and
+// are applied in publish order: by default the todo id (create/update/delete of one todo never race), or
+// the user id with KAFKA_PARTITION_KEY=user (all of a user's writes ordered, at the cost of hot users).
+func PartitionKey(cmd *models.TodoCommand) string {
+	if strings.ToLower(config.Get().KafkaPartitionKey) == "user" {
+		return cmd.UserID
+	}
+	return cmd.ID
+}
+
+func isSyncAction(action string) bool {
+	for _, a := range config.Get().KafkaSyncActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredAcks maps KAFKA_REQUIRED_ACKS (none, one, all) to the kafka-go setting; unknown values mean one.
+func requiredAcks(v string) kafka.RequiredAcks {
+	switch strings.ToLower(v) {
+	case "none", "0":
+		return kafka.RequireNone
+	case "all", "-1":
+		return kafka.RequireAll
+	default:
+		return kafka.RequireOne
+	}
+}
+
+// Close flushes pending async messages and closes the command writer. Call after the HTTP server has drained.
+func Close() error {
+	if writer != nil {
+		return writer.Close()
+	}
+	return nil
 }
 
 // Topic returns the todo commands topic name.
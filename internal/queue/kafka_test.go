@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"million-rps/internal/config"
+	"million-rps/internal/models"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestPartitionKey(t *testing.T) {
+	cfg := config.Get()
+	prev := cfg.KafkaPartitionKey
+	t.Cleanup(func() { cfg.KafkaPartitionKey = prev })
+	create := &models.TodoCommand{Action: "create", ID: "t1", UserID: "u1"}
+	del := &models.TodoCommand{Action: "delete", ID: "t1", UserID: "u1"}
+
+	cfg.KafkaPartitionKey = "todo"
+	if PartitionKey(create) != "t1" || PartitionKey(create) != PartitionKey(del) {
+		t.Errorf("todo strategy: keys %q / %q, want both t1", PartitionKey(create), PartitionKey(del))
+	}
+	cfg.KafkaPartitionKey = "user"
+	if PartitionKey(create) != "u1" || PartitionKey(create) != PartitionKey(del) {
+		t.Errorf("user strategy: keys %q / %q, want both u1", PartitionKey(create), PartitionKey(del))
+	}
+}
+
+func TestBrokersSplitsList(t *testing.T) {
+	cfg := config.Get()
+	prev := cfg.KafkaBrokers
+	t.Cleanup(func() { cfg.KafkaBrokers = prev })
+	cfg.KafkaBrokers = " b1:9092, b2:9092,,b3:9093 "
+	if got, want := Brokers(), []string{"b1:9092", "b2:9092", "b3:9093"}; !slices.Equal(got, want) {
+		t.Fatalf("Brokers() = %q, want %q", got, want)
+	}
+}
+
+func TestKafkaSecuritySettings(t *testing.T) {
+	for _, name := range []string{"", "plain", "SCRAM-SHA-256", "scram-sha-512"} {
+		mech, err := saslMechanism(name, "svc", "secret")
+		if err != nil || (name == "") != (mech == nil) {
+			t.Errorf("saslMechanism(%q) = %v, %v", name, mech, err)
+		}
+	}
+	if _, err := saslMechanism("gssapi", "svc", "secret"); err == nil {
+		t.Error("saslMechanism accepted an unsupported mechanism")
+	}
+	for _, name := range []string{"none", "gzip", "snappy", "lz4", "zstd"} {
+		if _, err := compression(name); err != nil {
+			t.Errorf("compression(%q): %v", name, err)
+		}
+	}
+	if _, err := compression("brotli"); err == nil {
+		t.Error("compression accepted an unsupported codec")
+	}
+
+	cfg := config.Get()
+	prevTLS, prevCA := cfg.KafkaTLS, cfg.KafkaTLSCAFile
+	t.Cleanup(func() { cfg.KafkaTLS, cfg.KafkaTLSCAFile = prevTLS, prevCA })
+	cfg.KafkaTLS, cfg.KafkaTLSCAFile = true, ""
+	if tlsCfg, _, err := security(); err != nil || tlsCfg == nil {
+		t.Fatalf("security() with KAFKA_TLS = %v, %v", tlsCfg, err)
+	}
+	cfg.KafkaTLSCAFile = t.TempDir() + "/missing.pem"
+	if _, _, err := security(); err == nil {
+		t.Fatal("security() accepted a missing CA file")
+	}
+}
+
+func TestCompletionWakesWaitingPublishers(t *testing.T) {
+	first, second := make(chan error, 1), make(chan error, 1)
+	onCompletion([]kafka.Message{{WriterData: first}, {WriterData: second}}, nil)
+	for _, done := range []chan error{first, second} {
+		if err := awaitDelivery(context.Background(), done); err != nil {
+			t.Fatalf("delivered message reported %v", err)
+		}
+	}
+
+	failure := errors.New("broker down")
+	onCompletion([]kafka.Message{{WriterData: first}}, failure)
+	if err := awaitDelivery(context.Background(), first); !errors.Is(err, failure) {
+		t.Fatalf("failed message reported %v, want %v", err, failure)
+	}
+}
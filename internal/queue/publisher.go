@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"million-rps/internal/models"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// CommandPublisher is the write-path surface the controller depends on. Kafka is the production
+// implementation; Memory buffers commands in-process for tests.
+type CommandPublisher interface {
+	PublishTodoCommand(ctx context.Context, cmd *models.TodoCommand) error
+}
+
+// BatchPublisher publishes several commands at once and returns only after all of them are durable.
+// The outbox relay depends on it.
+type BatchPublisher interface {
+	PublishTodoCommands(ctx context.Context, cmds []*models.TodoCommand) error
+}
+
+// Kafka implements CommandQueue with the package-level Kafka producers, a consumer-group reader and the
+// dead-letter topic.
+type Kafka struct{}
+
+var _ CommandQueue = Kafka{}
+
+func (Kafka) PublishTodoCommand(ctx context.Context, cmd *models.TodoCommand) error {
+	return PublishTodoCommand(ctx, cmd)
+}
+
+func (Kafka) PublishTodoCommands(ctx context.Context, cmds []*models.TodoCommand) error {
+	return PublishTodoCommands(ctx, cmds)
+}
+
+// Consumer returns a reader in the todo-workers consumer group; replicas share the topic's partitions.
+func (Kafka) Consumer(ctx context.Context) (Consumer, error) {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  Brokers(),
+		Dialer:   Dialer(),
+		Topic:    Topic(),
+		GroupID:  "todo-workers",
+		MinBytes: 1,
+		MaxBytes: 10e6,
+	}), nil
+}
+
+func (Kafka) PublishDeadLetter(ctx context.Context, msg Message, cause error, attempts int) error {
+	return PublishDeadLetter(ctx, msg, cause, attempts)
+}
+
+func (Kafka) Healthy() error {
+	if err := ProducerHealthy(); err != nil {
+		return fmt.Errorf("%w: %s", err, Stats().LastError)
+	}
+	return nil
+}
+
+func (Kafka) Flush() error { return Close() }
+func (Kafka) Close() error { return errors.Join(Close(), CloseDLQ()) }
+
+// Memory is an in-process CommandPublisher that keeps published commands until drained. Err, when set,
+// is returned from every publish to simulate a broker outage.
+type Memory struct {
+	mu   sync.Mutex
+	cmds []models.TodoCommand
+	Err  error
+}
+
+var (
+	_ CommandPublisher = (*Memory)(nil)
+	_ BatchPublisher   = (*Memory)(nil)
+)
+
+func (m *Memory) PublishTodoCommand(ctx context.Context, cmd *models.TodoCommand) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.cmds = append(m.cmds, *cmd)
+	return nil
+}
+
+func (m *Memory) PublishTodoCommands(ctx context.Context, cmds []*models.TodoCommand) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, cmd := range cmds {
+		m.cmds = append(m.cmds, *cmd)
+	}
+	return nil
+}
+
+// Drain returns and removes every command published so far, in publish order.
+func (m *Memory) Drain() []models.TodoCommand {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := m.cmds
+	m.cmds = nil
+	return out
+}
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"million-rps/internal/cache"
+	"million-rps/internal/config"
+	"million-rps/pkg/logger"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Message is the envelope every backend delivers to the worker. It reuses kafka-go's struct so the worker
+// pipeline (ordering by Key, in-order commits by Partition/Offset, lag from HighWaterMark) is backend-agnostic.
+type Message = kafka.Message
+
+// Consumer delivers commands to the worker. CommitMessages acknowledges, per partition, every message up to
+// and including the given ones; Close releases the consumer (not the queue).
+type Consumer interface {
+	FetchMessage(ctx context.Context) (Message, error)
+	CommitMessages(ctx context.Context, msgs ...Message) error
+	Close() error
+}
+
+// CommandQueue is a complete write-path backend: the API publishes to it, the outbox relay batch-publishes
+// to it, and the worker consumes from it and dead-letters commands it cannot apply. Selected by QUEUE_BACKEND.
+type CommandQueue interface {
+	CommandPublisher
+	BatchPublisher
+	Consumer(ctx context.Context) (Consumer, error)
+	PublishDeadLetter(ctx context.Context, msg Message, cause error, attempts int) error
+	// Healthy returns an error while publishing is failing; used by the readiness probe.
+	Healthy() error
+	// Flush delivers publishes still buffered in the process and stops accepting new ones. Call once the
+	// API and outbox relay have stopped.
+	Flush() error
+	// Close releases the backend, including the dead-letter side. Call after the worker has stopped.
+	Close() error
+}
+
+// Open returns the CommandQueue selected by QUEUE_BACKEND: "kafka" (default), "redis" (Redis Streams on the
+// cache's Redis, see RedisStreams) or "memory" (an in-process channel for single-node deployments and local
+// development; commands are lost on restart).
+func Open(ctx context.Context) (CommandQueue, error) {
+	cfg := config.Get()
+	switch strings.ToLower(cfg.QueueBackend) {
+	case "kafka", "":
+		if err := checkKafkaConfig(); err != nil {
+			return nil, err
+		}
+		Producer(ctx)
+		EnsureTopic(ctx)
+		return Kafka{}, nil
+	case "redis":
+		client := cache.Wait(ctx)
+		if client == nil {
+			return nil, errors.New("QUEUE_BACKEND=redis: redis unavailable")
+		}
+		return NewRedisStreams(client), nil
+	case "memory":
+		logger.Warn(ctx, "Using the in-process command queue; queued writes are lost on restart", "buffer", cfg.QueueMemoryBuffer)
+		return NewChannel(cfg.QueueMemoryBuffer), nil
+	default:
+		return nil, fmt.Errorf("unknown QUEUE_BACKEND %q (want kafka, redis or memory)", cfg.QueueBackend)
+	}
+}
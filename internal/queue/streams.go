@@ -0,0 +1,578 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/internal/models"
+	"million-rps/pkg/logger"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// streamGroup is the consumer group every worker joins, as with the Kafka backend.
+	streamGroup = "todo-workers"
+	// streamRead caps the entries read or reclaimed per round trip.
+	streamRead = 256
+	// streamBlock caps how long one XREADGROUP waits, bounding how late a cancelled worker or a newly
+	// leased shard is noticed.
+	streamBlock = time.Second
+	// streamRetry is the pause after a failed read so an unreachable Redis is not hammered.
+	streamRetry = time.Second
+
+	// Entry fields; the payload is the same JSON published to Kafka.
+	fieldKey   = "key"
+	fieldValue = "value"
+
+	// HeaderStreamID carries the Redis entry id of messages delivered by RedisStreams.
+	HeaderStreamID = "x-stream-id"
+)
+
+// leaseScript renews (ARGV[2] > 0 milliseconds) or releases (ARGV[2] = 0) the lease in KEYS[1] only if it
+// is still held by ARGV[1]. Returns 1 on success.
+var leaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == '0' then
+	return redis.call('DEL', KEYS[1])
+end
+return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+`)
+
+// RedisStreams is a CommandQueue on Redis Streams for deployments without Kafka. Commands are spread by
+// PartitionKey over REDIS_STREAM_SHARDS streams, the equivalent of Kafka partitions. Streams are never
+// trimmed by length: each shard's owner deletes only entries the group has read and acknowledged (see
+// trim), so a backlog costs Redis memory but is never lost. Workers share the shards through the
+// todo-workers consumer group, but each shard is read by one worker at a time (a lease renewed in Redis),
+// so commands for one key are still applied in order. A worker taking over a shard first reclaims, with XAUTOCLAIM, every entry the previous
+// owner read but has not acknowledged for a whole lease (it crashed), and reads nothing new from the shard
+// while younger entries are still pending with a live previous owner; replays are harmless since the worker
+// deduplicates by command id.
+type RedisStreams struct {
+	client *redis.Client
+	stream string
+	dlq    string
+	shards int
+	dlqLen int64
+	lease  time.Duration
+}
+
+var _ CommandQueue = (*RedisStreams)(nil)
+
+// NewRedisStreams returns the Redis Streams queue configured by the REDIS_STREAM* settings.
+func NewRedisStreams(client *redis.Client) *RedisStreams {
+	cfg := config.Get()
+	return &RedisStreams{
+		client: client,
+		stream: cfg.RedisStream,
+		dlq:    cfg.RedisStreamDLQ,
+		shards: max(cfg.RedisStreamShards, 1),
+		dlqLen: cfg.RedisStreamDLQMaxLen,
+		lease:  time.Duration(max(cfg.RedisStreamLease, 100)) * time.Millisecond,
+	}
+}
+
+// shardKey returns the stream holding shard i.
+func (r *RedisStreams) shardKey(i int) string {
+	return r.stream + ":" + strconv.Itoa(i)
+}
+
+// leaseKey returns the key naming the worker that currently reads shard i.
+func (r *RedisStreams) leaseKey(i int) string {
+	return r.shardKey(i) + ":owner"
+}
+
+// membersKey is a sorted set of live workers scored by their last heartbeat, used to split the shards.
+func (r *RedisStreams) membersKey() string {
+	return r.stream + ":workers"
+}
+
+// shardFor maps a partition key onto a shard.
+func (r *RedisStreams) shardFor(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(r.shards))
+}
+
+func (r *RedisStreams) PublishTodoCommand(ctx context.Context, cmd *models.TodoCommand) error {
+	return r.PublishTodoCommands(ctx, []*models.TodoCommand{cmd})
+}
+
+// PublishTodoCommands appends cmds in one pipeline; per-shard order matches cmds.
+func (r *RedisStreams) PublishTodoCommands(ctx context.Context, cmds []*models.TodoCommand) error {
+	pipe := r.client.Pipeline()
+	for _, cmd := range cmds {
+		payload, err := json.Marshal(cmd)
+		if err != nil {
+			return err
+		}
+		key := PartitionKey(cmd)
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.shardKey(r.shardFor(key)),
+			Values: []any{fieldKey, key, fieldValue, payload},
+		})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Consumer creates the consumer group on every shard (if missing) and joins it under a unique name.
+func (r *RedisStreams) Consumer(ctx context.Context) (Consumer, error) {
+	for i := range r.shards {
+		err := r.client.XGroupCreateMkStream(ctx, r.shardKey(i), streamGroup, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, fmt.Errorf("create consumer group on %s: %w", r.shardKey(i), err)
+		}
+	}
+	host, _ := os.Hostname()
+	c := &streamConsumer{
+		q:         r,
+		name:      host + "-" + uuid.NewString()[:8],
+		owned:     make(map[int]string),
+		reclaimAt: make(map[int]time.Time),
+		shards:    make(map[int]*shardState),
+		done:      make(chan struct{}),
+	}
+	c.balance(ctx)
+	bg, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c.stop = cancel
+	go c.keepLeases(bg)
+	return c, nil
+}
+
+// PublishDeadLetter appends msg to the dead-letter stream with the same metadata the Kafka DLQ carries as headers.
+func (r *RedisStreams) PublishDeadLetter(ctx context.Context, msg Message, cause error, attempts int) error {
+	reason := ""
+	if cause != nil {
+		reason = cause.Error()
+	}
+	offset := strconv.FormatInt(msg.Offset, 10)
+	for _, h := range msg.Headers {
+		if h.Key == HeaderStreamID {
+			offset = string(h.Value)
+		}
+	}
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.dlq,
+		MaxLen: r.dlqLen,
+		Approx: true,
+		Values: []any{
+			fieldKey, msg.Key,
+			fieldValue, msg.Value,
+			HeaderOriginalTopic, msg.Topic,
+			HeaderOriginalPartition, msg.Partition,
+			HeaderOriginalOffset, offset,
+			HeaderError, reason,
+			HeaderAttempts, attempts,
+			HeaderFailedAt, time.Now().UTC().Format(time.RFC3339Nano),
+		},
+	}).Err()
+}
+
+// Healthy pings Redis; publishes are synchronous, so a reachable Redis is all they need.
+func (r *RedisStreams) Healthy() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("redis streams unreachable: %w", err)
+	}
+	return nil
+}
+
+// Flush is a no-op: every publish has been written to Redis by the time it returns.
+func (r *RedisStreams) Flush() error { return nil }
+
+// Close is a no-op: the Redis client belongs to the cache and is closed with it.
+func (r *RedisStreams) Close() error { return nil }
+
+// streamConsumer is one worker's member of the consumer group. A background goroutine heartbeats and keeps
+// its shard leases (so a worker stalled on a slow database keeps them); FetchMessage reads from the leased
+// shards and CommitMessages acknowledges what the worker has settled.
+type streamConsumer struct {
+	q    *RedisStreams
+	name string
+	stop context.CancelFunc
+	done chan struct{}
+
+	buf []Message // read but not yet returned; FetchMessage only
+
+	mu    sync.Mutex
+	owned map[int]string // leased shard → XAUTOCLAIM cursor, "" once the previous owner's entries are reclaimed
+	// reclaimAt delays the next reclaim pass of a shard whose previous owner still has entries in flight.
+	reclaimAt map[int]time.Time
+	shards    map[int]*shardState
+}
+
+// shardState maps the offsets handed to the worker back to stream entry ids for acknowledgement.
+type shardState struct {
+	next    int64          // offset of the next entry delivered from the shard
+	pending []pendingEntry // delivered, not yet acknowledged, in offset order
+}
+
+type pendingEntry struct {
+	offset int64
+	id     string
+}
+
+// keepLeases rebalances and trims the leased shards every third of the lease period until ctx ends.
+func (c *streamConsumer) keepLeases(ctx context.Context) {
+	defer close(c.done)
+	t := time.NewTicker(c.q.lease / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.balance(ctx)
+			c.mu.Lock()
+			shards := make([]int, 0, len(c.owned))
+			for shard := range c.owned {
+				shards = append(shards, shard)
+			}
+			c.mu.Unlock()
+			for _, shard := range shards {
+				if err := c.trim(ctx, shard); err != nil && ctx.Err() == nil {
+					logger.Warn(ctx, "Stream trim failed", "stream", c.q.shardKey(shard), "error", err)
+				}
+			}
+		}
+	}
+}
+
+// trim deletes the entries of shard that the group has both read and acknowledged: everything below the
+// oldest pending entry and below the first entry not yet delivered. The group's state is read before the
+// trim, so entries read or acknowledged meanwhile only make the bound conservative.
+func (c *streamConsumer) trim(ctx context.Context, shard int) error {
+	stream := c.q.shardKey(shard)
+	groups, err := c.q.client.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		return err
+	}
+	minID := ""
+	for _, g := range groups {
+		if g.Name == streamGroup {
+			minID = nextStreamID(g.LastDeliveredID)
+		}
+	}
+	if minID == "" {
+		return nil
+	}
+	pending, err := c.q.client.XPending(ctx, stream, streamGroup).Result()
+	if err != nil {
+		return err
+	}
+	if pending.Count > 0 && lessStreamID(pending.Lower, minID) {
+		minID = pending.Lower
+	}
+	return c.q.client.XTrimMinID(ctx, stream, minID).Err()
+}
+
+// nextStreamID returns the smallest entry id after id ("<ms>-<seq>").
+func nextStreamID(id string) string {
+	ms, seq, _ := strings.Cut(id, "-")
+	n, _ := strconv.ParseUint(seq, 10, 64)
+	return ms + "-" + strconv.FormatUint(n+1, 10)
+}
+
+// lessStreamID reports whether entry id a sorts before b.
+func lessStreamID(a, b string) bool {
+	parse := func(id string) (uint64, uint64) {
+		ms, seq, _ := strings.Cut(id, "-")
+		m, _ := strconv.ParseUint(ms, 10, 64)
+		n, _ := strconv.ParseUint(seq, 10, 64)
+		return m, n
+	}
+	am, as := parse(a)
+	bm, bs := parse(b)
+	return am < bm || (am == bm && as < bs)
+}
+
+// FetchMessage returns the next entry from the shards this worker leases, reclaiming a newly leased shard's
+// pending entries before reading anything new from it. Offsets are per shard and increase in delivery
+// order; lag is not tracked, so HighWaterMark is always Offset+1.
+func (c *streamConsumer) FetchMessage(ctx context.Context) (Message, error) {
+	for len(c.buf) == 0 {
+		if err := ctx.Err(); err != nil {
+			return Message{}, err
+		}
+		if err := c.fill(ctx); err != nil {
+			if ctx.Err() == nil {
+				sleepCtx(ctx, streamRetry)
+			}
+			return Message{}, err
+		}
+	}
+	msg := c.buf[0]
+	c.buf = c.buf[1:]
+	return msg, nil
+}
+
+// fill reads the next entries into buf, or waits a while if no shard is readable yet.
+func (c *streamConsumer) fill(ctx context.Context) error {
+	c.mu.Lock()
+	var shards []int
+	now, wait := time.Now(), streamBlock
+	for shard, cursor := range c.owned {
+		if cursor == "" {
+			shards = append(shards, shard)
+			continue
+		}
+		if at := c.reclaimAt[shard]; now.Before(at) {
+			wait = min(wait, at.Sub(now))
+			continue
+		}
+		c.mu.Unlock()
+		return c.reclaim(ctx, shard, cursor)
+	}
+	c.mu.Unlock()
+	if len(shards) == 0 {
+		sleepCtx(ctx, wait)
+		return nil
+	}
+	sort.Ints(shards)
+	streams := make([]string, 0, 2*len(shards))
+	for _, shard := range shards {
+		streams = append(streams, c.q.shardKey(shard))
+	}
+	for range shards {
+		streams = append(streams, ">")
+	}
+	res, err := c.q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    streamGroup,
+		Consumer: c.name,
+		Streams:  streams,
+		Count:    streamRead,
+		Block:    streamBlock,
+	}).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, s := range res {
+		for i, shard := range shards {
+			if streams[i] == s.Stream {
+				c.deliver(ctx, shard, s.Messages)
+			}
+		}
+	}
+	return nil
+}
+
+// reclaim takes over the next page of entries other workers read from shard and have not acknowledged for
+// a whole lease. Younger entries may still be in a live previous owner's lanes (the shard was rebalanced
+// away from it), so they are left alone; once a pass finds nothing more to claim, the shard stays in
+// reclaim, re-checked every tenth of a lease, until no other worker has entries pending in it. Reading new
+// entries only then keeps a key's older commands from being applied after its newer ones.
+func (c *streamConsumer) reclaim(ctx context.Context, shard int, cursor string) error {
+	stream := c.q.shardKey(shard)
+	msgs, next, err := c.q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    streamGroup,
+		Consumer: c.name,
+		MinIdle:  c.q.lease,
+		Start:    cursor,
+		Count:    streamRead,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	if len(msgs) > 0 {
+		logger.Info(ctx, "Reclaimed pending stream entries", "stream", stream, "count", len(msgs))
+	}
+	c.deliver(ctx, shard, msgs)
+	var retryAt time.Time
+	if next == "0-0" {
+		pending, err := c.q.client.XPending(ctx, stream, streamGroup).Result()
+		if err != nil {
+			return err
+		}
+		next = ""
+		for name, n := range pending.Consumers {
+			if name != c.name && n > 0 {
+				next, retryAt = "0-0", time.Now().Add(c.q.lease/10)
+				break
+			}
+		}
+	}
+	c.mu.Lock()
+	if _, ok := c.owned[shard]; ok {
+		c.owned[shard] = next
+		c.reclaimAt[shard] = retryAt
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// deliver assigns offsets to entries read from shard and buffers them. Entries trimmed from the stream
+// while pending come back without fields and are acknowledged straight away.
+func (c *streamConsumer) deliver(ctx context.Context, shard int, entries []redis.XMessage) {
+	stream := c.q.shardKey(shard)
+	var gone []string
+	c.mu.Lock()
+	st := c.shards[shard]
+	if st == nil {
+		st = &shardState{}
+		c.shards[shard] = st
+	}
+	for _, e := range entries {
+		value, ok := e.Values[fieldValue].(string)
+		if !ok {
+			gone = append(gone, e.ID)
+			continue
+		}
+		key, _ := e.Values[fieldKey].(string)
+		st.pending = append(st.pending, pendingEntry{offset: st.next, id: e.ID})
+		c.buf = append(c.buf, Message{
+			Topic:         stream,
+			Partition:     shard,
+			Offset:        st.next,
+			HighWaterMark: st.next + 1,
+			Key:           []byte(key),
+			Value:         []byte(value),
+			Headers:       []kafka.Header{{Key: HeaderStreamID, Value: []byte(e.ID)}},
+		})
+		st.next++
+	}
+	c.mu.Unlock()
+	if len(gone) > 0 {
+		logger.Warn(ctx, "Pending stream entries were trimmed before being applied", "stream", stream, "count", len(gone))
+		if err := c.q.client.XAck(ctx, stream, streamGroup, gone...).Err(); err != nil {
+			logger.Error(ctx, "Stream ack failed", "error", err, "stream", stream)
+		}
+	}
+}
+
+// CommitMessages acknowledges, per shard, every delivered entry up to and including each message's offset.
+func (c *streamConsumer) CommitMessages(ctx context.Context, msgs ...Message) error {
+	acks := make(map[string][]string)
+	c.mu.Lock()
+	for _, msg := range msgs {
+		st := c.shards[msg.Partition]
+		if st == nil {
+			continue
+		}
+		n := sort.Search(len(st.pending), func(i int) bool { return st.pending[i].offset > msg.Offset })
+		for _, p := range st.pending[:n] {
+			acks[msg.Topic] = append(acks[msg.Topic], p.id)
+		}
+		st.pending = st.pending[n:]
+	}
+	c.mu.Unlock()
+	if len(acks) == 0 {
+		return nil
+	}
+	pipe := c.q.client.Pipeline()
+	for stream, ids := range acks {
+		pipe.XAck(ctx, stream, streamGroup, ids...)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// balance heartbeats this worker, renews its leases and then sheds or acquires shards so that each live
+// worker reads about the same number. A newly leased shard starts by reclaiming its pending entries.
+func (c *streamConsumer) balance(ctx context.Context) {
+	q := c.q
+	now := time.Now()
+	pipe := q.client.Pipeline()
+	pipe.ZAdd(ctx, q.membersKey(), redis.Z{Score: float64(now.UnixMilli()), Member: c.name})
+	pipe.ZRemRangeByScore(ctx, q.membersKey(), "-inf", strconv.FormatInt(now.Add(-q.lease).UnixMilli(), 10))
+	live := pipe.ZCard(ctx, q.membersKey())
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error(ctx, "Stream worker heartbeat failed", "error", err)
+		return
+	}
+	workers := int(max(live.Val(), 1))
+	target := (q.shards + workers - 1) / workers
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for shard := range c.owned {
+		if ok, err := leaseScript.Run(ctx, q.client, []string{q.leaseKey(shard)}, c.name, q.lease.Milliseconds()).Int(); err != nil || ok == 0 {
+			logger.Warn(ctx, "Lost stream shard lease", "stream", q.shardKey(shard), "error", err)
+			delete(c.owned, shard)
+			delete(c.reclaimAt, shard)
+		}
+	}
+	for len(c.owned) > target {
+		shard := -1
+		for s := range c.owned {
+			shard = max(shard, s)
+		}
+		c.release(ctx, shard)
+	}
+	for shard := 0; shard < q.shards && len(c.owned) < target; shard++ {
+		if _, ok := c.owned[shard]; ok {
+			continue
+		}
+		if ok, err := q.client.SetNX(ctx, q.leaseKey(shard), c.name, q.lease).Result(); err == nil && ok {
+			c.owned[shard] = "0-0"
+		}
+	}
+}
+
+// release gives up the lease on shard; the caller holds mu. Entries already delivered from it are still
+// acknowledged normally.
+func (c *streamConsumer) release(ctx context.Context, shard int) {
+	delete(c.owned, shard)
+	delete(c.reclaimAt, shard)
+	if err := leaseScript.Run(ctx, c.q.client, []string{c.q.leaseKey(shard)}, c.name, 0).Err(); err != nil {
+		logger.Warn(ctx, "Stream shard release failed", "stream", c.q.shardKey(shard), "error", err)
+	}
+}
+
+// Close releases every lease and leaves the worker set so the remaining workers take over at once, then
+// removes this consumer from the group on every shard where it has nothing pending, so consumer names do
+// not pile up across restarts. Unacknowledged entries stay pending under its name and are reclaimed by the
+// next owner.
+func (c *streamConsumer) Close() error {
+	c.stop()
+	<-c.done
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c.mu.Lock()
+	for shard := range c.owned {
+		c.release(ctx, shard)
+	}
+	c.mu.Unlock()
+	for i := range c.q.shards {
+		stream := c.q.shardKey(i)
+		pending, err := c.q.client.XPending(ctx, stream, streamGroup).Result()
+		if err != nil || pending.Consumers[c.name] > 0 {
+			continue
+		}
+		if err := c.q.client.XGroupDelConsumer(ctx, stream, streamGroup, c.name).Err(); err != nil {
+			logger.Warn(ctx, "Stream consumer removal failed", "stream", stream, "error", err)
+		}
+	}
+	return c.q.client.ZRem(ctx, c.q.membersKey(), c.name).Err()
+}
+
+// sleepCtx waits for d or until ctx ends.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
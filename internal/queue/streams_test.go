@@ -0,0 +1,271 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/internal/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+)
+
+// newTestStreams returns a RedisStreams with the given number of shards on a fresh in-memory Redis.
+func newTestStreams(t *testing.T, shards int) (*RedisStreams, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	cfg := config.Get()
+	prev := cfg.RedisStreamShards
+	t.Cleanup(func() { cfg.RedisStreamShards = prev })
+	cfg.RedisStreamShards = shards
+	return NewRedisStreams(client), mr
+}
+
+func fetchCommand(t *testing.T, c Consumer) (Message, models.TodoCommand) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := c.FetchMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmd models.TodoCommand
+	if err := json.Unmarshal(msg.Value, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	return msg, cmd
+}
+
+func TestRedisStreamsDeliversPerKeyInOrderAndAcks(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newTestStreams(t, 4)
+	c, err := q.Consumer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var cmds []*models.TodoCommand
+	for _, id := range []string{"a1", "b1", "a2", "c1", "b2", "a3"} {
+		cmds = append(cmds, &models.TodoCommand{CommandID: id, Action: "update", ID: "todo-" + id[:1]})
+	}
+	if err := q.PublishTodoCommands(ctx, cmds); err != nil {
+		t.Fatal(err)
+	}
+
+	last := make(map[string]string) // todo → last command seen; ids sort in publish order per todo
+	tail := make(map[int]Message)   // shard → last message delivered
+	for range cmds {
+		msg, cmd := fetchCommand(t, c)
+		if prev := last[cmd.ID]; cmd.CommandID <= prev {
+			t.Fatalf("%s delivered after %s", cmd.CommandID, prev)
+		}
+		last[cmd.ID] = cmd.CommandID
+		if prev, ok := tail[msg.Partition]; ok && msg.Offset != prev.Offset+1 {
+			t.Fatalf("shard %d offset %d after %d", msg.Partition, msg.Offset, prev.Offset)
+		}
+		if msg.Partition != q.shardFor(string(msg.Key)) {
+			t.Fatalf("%s read from shard %d, want %d", cmd.CommandID, msg.Partition, q.shardFor(string(msg.Key)))
+		}
+		tail[msg.Partition] = msg
+	}
+
+	for shard, msg := range tail {
+		if err := c.CommitMessages(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		pending, err := q.client.XPending(ctx, q.shardKey(shard), streamGroup).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending.Count != 0 {
+			t.Fatalf("shard %d has %d pending entries after commit", shard, pending.Count)
+		}
+	}
+}
+
+func TestRedisStreamsReclaimsEntriesOfCrashedWorker(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Get()
+	prevLease := cfg.RedisStreamLease
+	t.Cleanup(func() { cfg.RedisStreamLease = prevLease })
+	cfg.RedisStreamLease = 1000
+	q, mr := newTestStreams(t, 1)
+	crashed, err := q.Consumer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := q.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: id, Action: "update", ID: "t"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, _ := fetchCommand(t, crashed)
+	if err := crashed.CommitMessages(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	fetchCommand(t, crashed) // "2" is read but never acknowledged
+
+	// The worker dies without releasing its lease; it expires and a new worker takes the shard over.
+	sc := crashed.(*streamConsumer)
+	sc.stop()
+	<-sc.done
+	mr.FastForward(q.lease)
+	if err := q.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: "4", Action: "update", ID: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	next, err := q.Consumer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+
+	// "2" and "3" (buffered with it) were read less than a lease ago and may still be in the old owner's
+	// lanes: the new owner neither claims them nor reads past them yet.
+	waitCtx, cancel := context.WithTimeout(ctx, q.lease/2)
+	_, err = next.FetchMessage(waitCtx)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("new owner fetched while the old owner's entry was young: %v", err)
+	}
+	if pending, _ := q.client.XPending(ctx, q.shardKey(0), streamGroup).Result(); pending.Consumers[sc.name] != 2 {
+		t.Fatalf("pending by consumer = %v, want both read entries still with %s", pending.Consumers, sc.name)
+	}
+
+	// They are never acknowledged: once idle for a lease they are reclaimed, ahead of the newer entry.
+	var got []string
+	var msg Message
+	for range 3 {
+		var cmd models.TodoCommand
+		msg, cmd = fetchCommand(t, next)
+		got = append(got, cmd.CommandID)
+	}
+	if want := []string{"2", "3", "4"}; !slices.Equal(got, want) {
+		t.Fatalf("new owner delivered %v, want %v", got, want)
+	}
+	if err := next.CommitMessages(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := q.client.XPending(ctx, q.shardKey(0), streamGroup).Result(); pending.Count != 0 {
+		t.Fatalf("%d entries still pending", pending.Count)
+	}
+}
+
+func TestRedisStreamsTrimsOnlyAcknowledgedEntries(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newTestStreams(t, 1)
+	c, err := q.Consumer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := c.(*streamConsumer)
+	publish := func(id string) {
+		t.Helper()
+		if err := q.PublishTodoCommand(ctx, &models.TodoCommand{CommandID: id, Action: "update", ID: "t"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	length := func() int64 {
+		t.Helper()
+		if err := sc.trim(ctx, 0); err != nil {
+			t.Fatal(err)
+		}
+		return q.client.XLen(ctx, q.shardKey(0)).Val()
+	}
+
+	publish("1")
+	publish("2")
+	first, _ := fetchCommand(t, c) // reads "1" and "2"
+	if err := c.CommitMessages(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	publish("3") // not read yet
+	if n := length(); n != 2 {
+		t.Fatalf("stream length after trim = %d, want 2 (pending and unread entries kept)", n)
+	}
+	second, _ := fetchCommand(t, c)
+	if err := c.CommitMessages(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if n := length(); n != 1 {
+		t.Fatalf("stream length after trim = %d, want only the unread entry", n)
+	}
+
+	// Nothing pending: a clean shutdown removes the consumer from the group.
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	consumers, err := q.client.XInfoConsumers(ctx, q.shardKey(0), streamGroup).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range consumers {
+		if info.Name == sc.name {
+			t.Fatalf("closed consumer %s still in the group", sc.name)
+		}
+	}
+
+	// With an entry still pending the name stays, holding it for the next owner to reclaim.
+	busy, err := q.Consumer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetchCommand(t, busy)
+	if err := busy.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := q.client.XPending(ctx, q.shardKey(0), streamGroup).Result(); pending.Consumers[busy.(*streamConsumer).name] != 1 {
+		t.Fatalf("pending by consumer = %v, want the unacknowledged entry kept", pending.Consumers)
+	}
+}
+
+func TestRedisStreamsSplitsShardsBetweenWorkers(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newTestStreams(t, 4)
+	a, err := q.Consumer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := q.Consumer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	ca, cb := a.(*streamConsumer), b.(*streamConsumer)
+	ca.balance(ctx) // sheds half now that b has joined
+	cb.balance(ctx)
+	if len(ca.owned) != 2 || len(cb.owned) != 2 {
+		t.Fatalf("shards split %d/%d, want 2/2", len(ca.owned), len(cb.owned))
+	}
+	for shard := range ca.owned {
+		if _, ok := cb.owned[shard]; ok {
+			t.Fatalf("shard %d leased by both workers", shard)
+		}
+	}
+}
+
+func TestRedisStreamsDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newTestStreams(t, 1)
+	msg := Message{Topic: q.shardKey(0), Key: []byte("t"), Value: []byte("{"), Headers: []kafka.Header{{Key: HeaderStreamID, Value: []byte("7-0")}}}
+	if err := q.PublishDeadLetter(ctx, msg, errors.New("bad json"), 1); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := q.client.XRange(ctx, q.dlq, "-", "+").Result()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("dead letters = %v (%v)", entries, err)
+	}
+	v := entries[0].Values
+	if v[fieldValue] != "{" || v[HeaderError] != "bad json" || v[HeaderOriginalOffset] != "7-0" {
+		t.Fatalf("dead letter fields = %v", v)
+	}
+}
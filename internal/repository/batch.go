@@ -0,0 +1,322 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"million-rps/internal/database"
+	"million-rps/internal/models"
+	"million-rps/pkg/logger"
+
+	"github.com/lib/pq"
+)
+
+// ApplyBatch applies cmds in order inside one transaction and returns each command's result (nil, a
+// rejection such as ErrNotFound/ErrConflict, a *DuplicateError for a command id that was already processed,
+// or a permanent error such as an unknown action). Commands for the same todo are coalesced, so the database
+// sees at most one write per row: one multi-row INSERT for new todos, one UPDATE ... FROM (VALUES ...) for
+// changed ones and one DELETE ... ANY for removed ones. Applied and rejected command ids are recorded in
+// processed_commands in the same transaction. A non-nil error means nothing was written.
+func ApplyBatch(ctx context.Context, cmds []*models.TodoCommand) ([]error, error) {
+	db := database.DB(ctx)
+	if db == nil {
+		return nil, sql.ErrConnDone
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the existing rows in id order so concurrent batches cannot deadlock.
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, title, description, completed, user_id, created_at, updated_at, version
+		 FROM todos WHERE id = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(batchIDs(cmds)))
+	if err != nil {
+		logger.Error(ctx, "Repository ApplyBatch lock failed", "error", err)
+		return nil, err
+	}
+	before := make(map[string]models.Todo, len(cmds))
+	for rows.Next() {
+		var t models.Todo
+		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.UserID, &t.CreatedAt, &t.UpdatedAt, &t.Version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		before[t.ID] = t
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	seen, err := processedOutcomes(ctx, tx, cmds)
+	if err != nil {
+		logger.Error(ctx, "Repository ApplyBatch processed lookup failed", "error", err)
+		return nil, err
+	}
+	known := len(seen)
+	after := make(map[string]models.Todo, len(before))
+	for id, t := range before {
+		after[id] = t
+	}
+	results := applyCommands(after, seen, cmds, time.Now())
+
+	var inserts, updates []models.Todo
+	var deletes []string
+	for _, id := range batchIDs(cmds) {
+		old, had := before[id]
+		cur, has := after[id]
+		switch {
+		case !had && has:
+			inserts = append(inserts, cur)
+		case had && !has:
+			deletes = append(deletes, id)
+		case had && has && cur != old:
+			updates = append(updates, cur)
+		}
+	}
+	if err := insertTodos(ctx, tx, inserts); err != nil {
+		logger.Error(ctx, "Repository ApplyBatch insert failed", "error", err, "rows", len(inserts))
+		return nil, err
+	}
+	if err := updateTodos(ctx, tx, updates); err != nil {
+		logger.Error(ctx, "Repository ApplyBatch update failed", "error", err, "rows", len(updates))
+		return nil, err
+	}
+	if len(deletes) > 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM todos WHERE id = ANY($1)`, pq.Array(deletes)); err != nil {
+			logger.Error(ctx, "Repository ApplyBatch delete failed", "error", err, "rows", len(deletes))
+			return nil, err
+		}
+	}
+	if len(seen) > known {
+		if err := recordProcessed(ctx, tx, cmds, results); err != nil {
+			logger.Error(ctx, "Repository ApplyBatch record processed failed", "error", err)
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// insertTodos writes todos with one multi-row INSERT.
+func insertTodos(ctx context.Context, tx *sql.Tx, todos []models.Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString(`INSERT INTO todos (id, title, description, completed, user_id, created_at, updated_at, version) VALUES `)
+	args := make([]interface{}, 0, 8*len(todos))
+	for i, t := range todos {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		n := len(args)
+		fmt.Fprintf(&b, "($%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8)
+		args = append(args, t.ID, t.Title, t.Description, t.Completed, t.UserID, t.CreatedAt, t.UpdatedAt, t.Version)
+	}
+	b.WriteString(` ON CONFLICT (id) DO NOTHING`)
+	_, err := tx.ExecContext(ctx, b.String(), args...)
+	return err
+}
+
+// updateTodos writes the final state of todos with one UPDATE ... FROM (VALUES ...).
+func updateTodos(ctx context.Context, tx *sql.Tx, todos []models.Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString(`UPDATE todos SET title = v.title, description = v.description, completed = v.completed,
+		user_id = v.user_id, created_at = v.created_at, updated_at = v.updated_at, version = v.version FROM (VALUES `)
+	args := make([]interface{}, 0, 8*len(todos))
+	for i, t := range todos {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		n := len(args)
+		// Casts on every row keep the VALUES column types explicit for the planner.
+		fmt.Fprintf(&b, "($%d::TEXT,$%d::TEXT,$%d::TEXT,$%d::BOOLEAN,$%d::TEXT,$%d::TIMESTAMPTZ,$%d::TIMESTAMPTZ,$%d::BIGINT)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8)
+		args = append(args, t.ID, t.Title, t.Description, t.Completed, t.UserID, t.CreatedAt, t.UpdatedAt, t.Version)
+	}
+	b.WriteString(`) AS v(id, title, description, completed, user_id, created_at, updated_at, version) WHERE todos.id = v.id`)
+	_, err := tx.ExecContext(ctx, b.String(), args...)
+	return err
+}
+
+// processedOutcomes loads the recorded outcome of every command in cmds that was already processed.
+func processedOutcomes(ctx context.Context, tx *sql.Tx, cmds []*models.TodoCommand) (map[string]outcome, error) {
+	ids := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		if cmd.CommandID != "" {
+			ids = append(ids, cmd.CommandID)
+		}
+	}
+	seen := make(map[string]outcome)
+	if len(ids) == 0 {
+		return seen, nil
+	}
+	rows, err := tx.QueryContext(ctx,
+		`SELECT command_id, status, reason FROM processed_commands WHERE command_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var o outcome
+		if err := rows.Scan(&id, &o.status, &o.reason); err != nil {
+			return nil, err
+		}
+		seen[id] = o
+	}
+	return seen, rows.Err()
+}
+
+// recordProcessed inserts the outcome of every command settled by this batch. A command id inserted
+// concurrently by another worker fails the batch with a unique violation, so the command is never applied twice.
+func recordProcessed(ctx context.Context, tx *sql.Tx, cmds []*models.TodoCommand, results []error) error {
+	var b strings.Builder
+	b.WriteString(`INSERT INTO processed_commands (command_id, status, reason) VALUES `)
+	args := make([]interface{}, 0, 3*len(cmds))
+	for i, cmd := range cmds {
+		o, ok := outcomeOf(results[i])
+		if cmd.CommandID == "" || !ok {
+			continue
+		}
+		if len(args) > 0 {
+			b.WriteString(",")
+		}
+		n := len(args)
+		fmt.Fprintf(&b, "($%d,$%d,$%d)", n+1, n+2, n+3)
+		args = append(args, cmd.CommandID, o.status, o.reason)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, b.String(), args...)
+	return err
+}
+
+// batchIDs returns the distinct todo ids in cmds, in first-seen order.
+func batchIDs(cmds []*models.TodoCommand) []string {
+	seen := make(map[string]bool, len(cmds))
+	ids := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		if !seen[cmd.ID] {
+			seen[cmd.ID] = true
+			ids = append(ids, cmd.ID)
+		}
+	}
+	return ids
+}
+
+// outcome is the recorded result of a processed command.
+type outcome struct {
+	status, reason string
+	at             time.Time // when it was processed; only tracked by Memory
+}
+
+// outcomeOf maps a command result to the outcome recorded in processed_commands. Permanent failures are not
+// recorded (ok is false) so the command can still be fixed and replayed from the dead-letter topic.
+func outcomeOf(err error) (o outcome, ok bool) {
+	switch {
+	case err == nil:
+		return outcome{status: models.CommandApplied}, true
+	case IsRejection(err):
+		return outcome{status: RejectionStatus(err), reason: err.Error()}, true
+	default:
+		return outcome{}, false
+	}
+}
+
+// applyCommands applies cmds in order to todos (keyed by id; a missing key is a missing row) with the same
+// rules as the SQL in ApplyBatch, and returns each command's result. Rejected commands leave todos
+// unchanged. Commands whose id is in seen are skipped with a *DuplicateError; newly settled ones are added
+// to seen, which also catches a command delivered twice within one batch.
+func applyCommands(todos map[string]models.Todo, seen map[string]outcome, cmds []*models.TodoCommand, now time.Time) []error {
+	results := make([]error, len(cmds))
+	for i, cmd := range cmds {
+		if o, dup := seen[cmd.CommandID]; dup && cmd.CommandID != "" {
+			results[i] = &DuplicateError{CommandID: cmd.CommandID, Status: o.status, Reason: o.reason}
+			continue
+		}
+		results[i] = applyOne(todos, cmd, now)
+		if o, ok := outcomeOf(results[i]); ok && cmd.CommandID != "" {
+			o.at = now
+			seen[cmd.CommandID] = o
+		}
+	}
+	return results
+}
+
+func applyOne(todos map[string]models.Todo, cmd *models.TodoCommand, now time.Time) error {
+	if cmd.Action == "create" {
+		if t, ok := todos[cmd.ID]; ok {
+			// Same as INSERT ... ON CONFLICT (id) DO NOTHING: a replayed create is a no-op, unless the id
+			// is taken by someone else.
+			return CheckOwner(&t, cmd.UserID)
+		}
+		t := models.Todo{ID: cmd.ID, Title: cmd.Title, Description: cmd.Description, UserID: cmd.UserID,
+			CreatedAt: now, UpdatedAt: now, Version: 1}
+		if cmd.Completed != nil {
+			t.Completed = *cmd.Completed
+		}
+		todos[cmd.ID] = t
+		return nil
+	}
+	if cmd.Action != "update" && cmd.Action != "delete" {
+		return fmt.Errorf("unknown action %q", cmd.Action)
+	}
+	if cmd.Action == "update" && cmd.Title == "" && cmd.Description == "" && cmd.Completed == nil {
+		return ErrNoChanges
+	}
+	t, ok := todos[cmd.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := CheckOwner(&t, cmd.UserID); err != nil {
+		return err
+	}
+	if cmd.ExpectedVersion != nil && t.Version != *cmd.ExpectedVersion {
+		return ErrConflict
+	}
+	if cmd.Action == "delete" {
+		delete(todos, cmd.ID)
+		return nil
+	}
+	if cmd.Title != "" {
+		t.Title = cmd.Title
+	}
+	if cmd.Description != "" {
+		t.Description = cmd.Description
+	}
+	if cmd.Completed != nil {
+		t.Completed = *cmd.Completed
+	}
+	t.UpdatedAt = now
+	t.Version++
+	todos[cmd.ID] = t
+	return nil
+}
+
+// PruneProcessed deletes processed_commands rows older than age and returns how many were removed. Redelivery
+// can only happen within Kafka's retention, so older ids are never needed again.
+func PruneProcessed(ctx context.Context, age time.Duration) (int64, error) {
+	db := database.DB(ctx)
+	if db == nil {
+		return 0, sql.ErrConnDone
+	}
+	res, err := db.ExecContext(ctx, `DELETE FROM processed_commands WHERE processed_at < $1`, time.Now().Add(-age))
+	if err != nil {
+		logger.Error(ctx, "Repository PruneProcessed failed", "error", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"million-rps/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Memory is an in-memory TodoStore with the same semantics as Postgres (newest first, user-scoped writes).
+// Safe for concurrent use.
+type Memory struct {
+	mu        sync.RWMutex
+	todos     map[string]models.Todo
+	processed map[string]outcome
+	outbox    []outboxEntry
+}
+
+// outboxEntry is one command in the Memory outbox; sent is zero until relayed.
+type outboxEntry struct {
+	cmd  models.TodoCommand
+	sent time.Time
+}
+
+var _ TodoStore = (*Memory)(nil)
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{todos: make(map[string]models.Todo), processed: make(map[string]outcome)}
+}
+
+// sorted returns todos matching keep in (created_at DESC, id DESC) order.
+func (m *Memory) sorted(keep func(models.Todo) bool) []models.Todo {
+	m.mu.RLock()
+	out := make([]models.Todo, 0, len(m.todos))
+	for _, t := range m.todos {
+		if keep == nil || keep(t) {
+			out = append(out, t)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.After(out[j].CreatedAt)
+		}
+		return out[i].ID > out[j].ID
+	})
+	return out
+}
+
+func (m *Memory) GetAll(ctx context.Context) ([]models.Todo, error) {
+	return m.sorted(nil), nil
+}
+
+func (m *Memory) GetRange(ctx context.Context, limit, offset int) ([]models.Todo, error) {
+	all := m.sorted(nil)
+	if limit <= 0 {
+		return all, nil
+	}
+	if offset >= len(all) {
+		return []models.Todo{}, nil
+	}
+	all = all[offset:]
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+func (m *Memory) GetPage(ctx context.Context, limit int, cursor string) (*models.TodoPage, error) {
+	keep := func(models.Todo) bool { return true }
+	if cursor != "" {
+		createdAt, id, err := DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		keep = func(t models.Todo) bool {
+			return t.CreatedAt.Before(createdAt) || (t.CreatedAt.Equal(createdAt) && t.ID < id)
+		}
+	}
+	items := m.sorted(keep)
+	page := &models.TodoPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = EncodeCursor(page.Items[limit-1])
+	}
+	return page, nil
+}
+
+func (m *Memory) GetByUser(ctx context.Context, userID string, limit int) ([]models.Todo, error) {
+	todos := m.sorted(func(t models.Todo) bool { return t.UserID == userID })
+	if limit > 0 && len(todos) > limit {
+		todos = todos[:limit]
+	}
+	return todos, nil
+}
+
+func (m *Memory) GetByID(ctx context.Context, id string) (*models.Todo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.todos[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+// Create seeds a todo directly, bypassing commands. For tests; it is not part of TodoStore.
+func (m *Memory) Create(ctx context.Context, todo *models.Todo) error {
+	if todo.ID == "" {
+		todo.ID = uuid.New().String()
+	}
+	now := time.Now()
+	todo.CreatedAt = now
+	todo.UpdatedAt = now
+	todo.Version = 1
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.todos[todo.ID]; ok {
+		return fmt.Errorf("duplicate todo id %q", todo.ID)
+	}
+	m.todos[todo.ID] = *todo
+	return nil
+}
+
+func (m *Memory) ApplyBatch(ctx context.Context, cmds []*models.TodoCommand) ([]error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return applyCommands(m.todos, m.processed, cmds, time.Now()), nil
+}
+
+func (m *Memory) PruneProcessed(ctx context.Context, age time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-age)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, o := range m.processed {
+		if o.at.Before(cutoff) {
+			delete(m.processed, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) EnqueueCommand(ctx context.Context, cmd *models.TodoCommand) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.outbox {
+		if e.cmd.CommandID == cmd.CommandID {
+			return nil
+		}
+	}
+	m.outbox = append(m.outbox, outboxEntry{cmd: *cmd})
+	return nil
+}
+
+func (m *Memory) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, []*models.TodoCommand) error) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var idx []int
+	var cmds []*models.TodoCommand
+	for i := range m.outbox {
+		if len(idx) == limit {
+			break
+		}
+		if m.outbox[i].sent.IsZero() {
+			idx = append(idx, i)
+			cmd := m.outbox[i].cmd
+			cmds = append(cmds, &cmd)
+		}
+	}
+	if len(idx) == 0 {
+		return 0, nil
+	}
+	if err := publish(ctx, cmds); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for _, i := range idx {
+		m.outbox[i].sent = now
+	}
+	return len(idx), nil
+}
+
+func (m *Memory) PruneOutbox(ctx context.Context, age time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-age)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.outbox[:0]
+	for _, e := range m.outbox {
+		if e.sent.IsZero() || !e.sent.Before(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	n := int64(len(m.outbox) - len(kept))
+	m.outbox = kept
+	return n, nil
+}
+
+// Unsent returns how many outbox commands have not been relayed yet.
+func (m *Memory) Unsent() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := 0
+	for _, e := range m.outbox {
+		if e.sent.IsZero() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"million-rps/internal/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	todo := models.Todo{ID: "abc", CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 123456000, time.UTC)}
+	createdAt, id, err := DecodeCursor(EncodeCursor(todo))
+	if err != nil {
+		t.Fatalf("DecodeCursor: %v", err)
+	}
+	if id != "abc" || !createdAt.Equal(todo.CreatedAt) {
+		t.Fatalf("got (%v, %q), want (%v, %q)", createdAt, id, todo.CreatedAt, "abc")
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, c := range []string{"!!!", "bm8tc2VwYXJhdG9y", "bm90LWEtdGltZXxpZA"} {
+		if _, _, err := DecodeCursor(c); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) err = %v, want ErrInvalidCursor", c, err)
+		}
+	}
+}
+
+func TestMemoryPagesThroughEveryTodoOnce(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	for i := 0; i < 7; i++ {
+		if err := m.Create(ctx, &models.Todo{Title: "t", UserID: "u"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := map[string]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination did not terminate")
+		}
+		page, err := m.GetPage(ctx, 3, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, todo := range page.Items {
+			if seen[todo.ID] {
+				t.Fatalf("todo %s returned twice", todo.ID)
+			}
+			seen[todo.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if len(seen) != 7 {
+		t.Fatalf("saw %d todos, want 7", len(seen))
+	}
+}
+
+// apply runs one command through ApplyBatch and returns its result.
+func apply(t *testing.T, m *Memory, cmd *models.TodoCommand) error {
+	t.Helper()
+	results, err := m.ApplyBatch(context.Background(), []*models.TodoCommand{cmd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results[0]
+}
+
+func TestMemoryWritesAreScopedToOwner(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	todo := &models.Todo{Title: "mine", UserID: "alice"}
+	if err := m.Create(ctx, todo); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(t, m, &models.TodoCommand{Action: "update", ID: todo.ID, Title: "stolen", UserID: "bob"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("foreign update err = %v, want ErrForbidden", err)
+	}
+	if err := apply(t, m, &models.TodoCommand{Action: "delete", ID: todo.ID, UserID: "bob"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("foreign delete err = %v, want ErrForbidden", err)
+	}
+	if err := apply(t, m, &models.TodoCommand{Action: "update", ID: todo.ID, UserID: "alice"}); !errors.Is(err, ErrNoChanges) {
+		t.Fatalf("empty update err = %v, want ErrNoChanges", err)
+	}
+	if err := apply(t, m, &models.TodoCommand{Action: "delete", ID: "missing", UserID: "alice"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("delete(missing) err = %v, want ErrNotFound", err)
+	}
+	got, err := m.GetByID(ctx, todo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "mine" {
+		t.Fatalf("title = %q, want unchanged", got.Title)
+	}
+	if _, err := m.GetByID(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetByID(missing) err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryRejectsStaleVersion(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	todo := &models.Todo{Title: "v1", UserID: "alice"}
+	_ = m.Create(ctx, todo)
+	v1 := int64(1)
+	if err := apply(t, m, &models.TodoCommand{Action: "update", ID: todo.ID, Title: "v2", UserID: "alice", ExpectedVersion: &v1}); err != nil {
+		t.Fatalf("update at current version: %v", err)
+	}
+	if err := apply(t, m, &models.TodoCommand{Action: "update", ID: todo.ID, Title: "lost", UserID: "alice", ExpectedVersion: &v1}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("stale update err = %v, want ErrConflict", err)
+	}
+	if err := apply(t, m, &models.TodoCommand{Action: "delete", ID: todo.ID, UserID: "alice", ExpectedVersion: &v1}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("stale delete err = %v, want ErrConflict", err)
+	}
+	got, _ := m.GetByID(ctx, todo.ID)
+	if got.Title != "v2" || got.Version != 2 {
+		t.Fatalf("got %+v, want title v2 at version 2", got)
+	}
+}
+
+func TestApplyBatchCoalescesInOrder(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	_ = m.Create(ctx, &models.Todo{ID: "old", Title: "x", UserID: "alice"})
+	done, v1 := true, int64(1)
+	cmds := []*models.TodoCommand{
+		{Action: "create", ID: "t1", Title: "a", UserID: "alice"},
+		{Action: "update", ID: "t1", Completed: &done, UserID: "alice", ExpectedVersion: &v1},
+		{Action: "update", ID: "t1", Title: "stale", UserID: "alice", ExpectedVersion: &v1},
+		{Action: "delete", ID: "old", UserID: "bob"},
+		{Action: "delete", ID: "old", UserID: "alice"},
+		{Action: "update", ID: "old", Title: "gone", UserID: "alice"},
+		{Action: "create", ID: "t1", Title: "again", UserID: "alice"},
+	}
+	results, err := m.ApplyBatch(ctx, cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []error{nil, nil, ErrConflict, ErrForbidden, nil, ErrNotFound}
+	for i, w := range want {
+		if !errors.Is(results[i], w) || (w == nil && results[i] != nil) {
+			t.Errorf("cmd %d result = %v, want %v", i, results[i], w)
+		}
+	}
+	if results[6] != nil {
+		t.Errorf("replayed create result = %v, want nil (no-op)", results[6])
+	}
+	got, err := m.GetByID(ctx, "t1")
+	if err != nil || got.Title != "a" || !got.Completed || got.Version != 2 {
+		t.Fatalf("t1 = %+v, %v; want title a, completed, version 2", got, err)
+	}
+	if _, err := m.GetByID(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("old err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestApplyBatchDeduplicatesCommandIDs(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	create := &models.TodoCommand{CommandID: "c1", Action: "create", ID: "t1", Title: "a", UserID: "alice"}
+	update := &models.TodoCommand{CommandID: "c2", Action: "update", ID: "t1", Title: "b", UserID: "alice"}
+	stolen := &models.TodoCommand{CommandID: "c3", Action: "create", ID: "t1", Title: "mine", UserID: "bob"}
+	if _, err := m.ApplyBatch(ctx, []*models.TodoCommand{create, update, update}); err != nil {
+		t.Fatal(err)
+	}
+	results, _ := m.ApplyBatch(ctx, []*models.TodoCommand{create, update, stolen})
+	for i, res := range results[:2] {
+		var dup *DuplicateError
+		if !errors.As(res, &dup) || dup.Status != models.CommandApplied {
+			t.Errorf("redelivered cmd %d result = %v, want DuplicateError(applied)", i, res)
+		}
+	}
+	if !errors.Is(results[2], ErrForbidden) {
+		t.Errorf("create on another user's id = %v, want ErrForbidden", results[2])
+	}
+	if got, _ := m.GetByID(ctx, "t1"); got.Title != "b" || got.Version != 2 {
+		t.Fatalf("t1 = %+v, want title b at version 2 (update applied once)", got)
+	}
+
+	// Rejections are remembered too, with their reason.
+	results, _ = m.ApplyBatch(ctx, []*models.TodoCommand{stolen})
+	var dup *DuplicateError
+	if !errors.As(results[0], &dup) || dup.Status != models.CommandRejected || dup.Reason == "" {
+		t.Fatalf("redelivered rejection = %v, want DuplicateError(rejected)", results[0])
+	}
+	if n, _ := m.PruneProcessed(ctx, 0); n != 3 {
+		t.Fatalf("PruneProcessed removed %d ids, want 3", n)
+	}
+}
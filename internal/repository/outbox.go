@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"million-rps/internal/database"
+	"million-rps/internal/models"
+	"million-rps/pkg/logger"
+
+	"github.com/lib/pq"
+)
+
+// outboxLockID is the transaction-scoped advisory lock held while relaying, so only one replica relays at a
+// time and commands leave the outbox in the order they were accepted.
+const outboxLockID = 724_118_002
+
+// EnqueueCommand stores cmd in the outbox; the relay publishes it later. Enqueueing the same command id
+// twice is a no-op.
+func EnqueueCommand(ctx context.Context, cmd *models.TodoCommand) error {
+	db := database.DB(ctx)
+	if db == nil {
+		return sql.ErrConnDone
+	}
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx,
+		`INSERT INTO outbox (command_id, payload) VALUES ($1, $2) ON CONFLICT (command_id) DO NOTHING`,
+		cmd.CommandID, payload)
+	if err != nil {
+		logger.Error(ctx, "Repository EnqueueCommand failed", "error", err, "command_id", cmd.CommandID)
+		return err
+	}
+	return nil
+}
+
+// RelayOutbox hands up to limit unsent commands, oldest first, to publish and marks them sent if it returns
+// nil. The rows stay locked until then, and if another replica is already relaying it returns (0, nil).
+// A crash after publish but before the commit re-publishes the batch, which the worker deduplicates.
+func RelayOutbox(ctx context.Context, limit int, publish func(context.Context, []*models.TodoCommand) error) (int, error) {
+	db := database.DB(ctx)
+	if db == nil {
+		return 0, sql.ErrConnDone
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, outboxLockID).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, payload FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	var cmds []*models.TodoCommand
+	for rows.Next() {
+		var id int64
+		var payload []byte
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		var cmd models.TodoCommand
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			// Cannot happen for rows written by EnqueueCommand; skip rather than block the outbox.
+			logger.Error(ctx, "Repository RelayOutbox bad payload; marking sent", "error", err, "id", id)
+			continue
+		}
+		cmds = append(cmds, &cmd)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if len(cmds) > 0 {
+		if err := publish(ctx, cmds); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE outbox SET sent_at = NOW() WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// PruneOutbox deletes outbox rows sent more than age ago and returns how many were removed.
+func PruneOutbox(ctx context.Context, age time.Duration) (int64, error) {
+	db := database.DB(ctx)
+	if db == nil {
+		return 0, sql.ErrConnDone
+	}
+	res, err := db.ExecContext(ctx, `DELETE FROM outbox WHERE sent_at < $1`, time.Now().Add(-age))
+	if err != nil {
+		logger.Error(ctx, "Repository PruneOutbox failed", "error", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"million-rps/internal/models"
+)
+
+// TodoStore is the persistence surface the controller and worker depend on. Postgres is the production
+// implementation; Memory backs tests and single-process runs. Every write goes through ApplyBatch.
+type TodoStore interface {
+	GetAll(ctx context.Context) ([]models.Todo, error)
+	GetRange(ctx context.Context, limit, offset int) ([]models.Todo, error)
+	GetPage(ctx context.Context, limit int, cursor string) (*models.TodoPage, error)
+	GetByUser(ctx context.Context, userID string, limit int) ([]models.Todo, error)
+	GetByID(ctx context.Context, id string) (*models.Todo, error)
+	ApplyBatch(ctx context.Context, cmds []*models.TodoCommand) ([]error, error)
+	PruneProcessed(ctx context.Context, age time.Duration) (int64, error)
+
+	EnqueueCommand(ctx context.Context, cmd *models.TodoCommand) error
+	RelayOutbox(ctx context.Context, limit int, publish func(context.Context, []*models.TodoCommand) error) (int, error)
+	PruneOutbox(ctx context.Context, age time.Duration) (int64, error)
+}
+
+// Postgres implements TodoStore with the package-level functions over database.DB.
+type Postgres struct{}
+
+var _ TodoStore = Postgres{}
+
+func (Postgres) GetAll(ctx context.Context) ([]models.Todo, error) { return GetAll(ctx) }
+
+func (Postgres) GetRange(ctx context.Context, limit, offset int) ([]models.Todo, error) {
+	return GetRange(ctx, limit, offset)
+}
+
+func (Postgres) GetPage(ctx context.Context, limit int, cursor string) (*models.TodoPage, error) {
+	return GetPage(ctx, limit, cursor)
+}
+
+func (Postgres) GetByUser(ctx context.Context, userID string, limit int) ([]models.Todo, error) {
+	return GetByUser(ctx, userID, limit)
+}
+
+func (Postgres) GetByID(ctx context.Context, id string) (*models.Todo, error) {
+	return GetByID(ctx, id)
+}
+
+func (Postgres) ApplyBatch(ctx context.Context, cmds []*models.TodoCommand) ([]error, error) {
+	return ApplyBatch(ctx, cmds)
+}
+
+func (Postgres) PruneProcessed(ctx context.Context, age time.Duration) (int64, error) {
+	return PruneProcessed(ctx, age)
+}
+
+func (Postgres) EnqueueCommand(ctx context.Context, cmd *models.TodoCommand) error {
+	return EnqueueCommand(ctx, cmd)
+}
+
+func (Postgres) RelayOutbox(ctx context.Context, limit int, publish func(context.Context, []*models.TodoCommand) error) (int, error) {
+	return RelayOutbox(ctx, limit, publish)
+}
+
+func (Postgres) PruneOutbox(ctx context.Context, age time.Duration) (int64, error) {
+	return PruneOutbox(ctx, age)
+}
@@ -3,23 +3,38 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"million-rps/internal/database"
 	"million-rps/internal/models"
 	"million-rps/pkg/logger"
+)
 
-	"github.com/google/uuid"
+var (
+	// ErrNotFound is returned when no todo matches the requested id.
+	ErrNotFound = errors.New("todo not found")
+	// ErrInvalidCursor is returned when a pagination cursor cannot be decoded.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrForbidden is returned when a write targets a todo owned by another user.
+	ErrForbidden = errors.New("todo belongs to another user")
+	// ErrNoChanges is returned by Update when no field is set.
+	ErrNoChanges = errors.New("no fields to update")
+	// ErrConflict is returned when a write's expected version no longer matches the stored todo.
+	ErrConflict = errors.New("todo version conflict")
 )
 
 // GetAll returns all todos from the database.
 func GetAll(ctx context.Context) ([]models.Todo, error) {
 	db := database.DB(ctx)
 	if db == nil {
-		return nil, sql.ErrNoRows
+		return nil, sql.ErrConnDone
 	}
 	rows, err := db.QueryContext(ctx,
-		`SELECT id, title, description, completed, user_id, created_at, updated_at FROM todos ORDER BY created_at DESC`)
+		`SELECT id, title, description, completed, user_id, created_at, updated_at, version FROM todos ORDER BY created_at DESC`)
 	if err != nil {
 		if ctx.Err() == nil {
 			logger.Error(ctx, "Repository GetTodos failed", "error", err)
@@ -30,7 +45,7 @@ func GetAll(ctx context.Context) ([]models.Todo, error) {
 	var todos []models.Todo
 	for rows.Next() {
 		var t models.Todo
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.UserID, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.UserID, &t.CreatedAt, &t.UpdatedAt, &t.Version); err != nil {
 			if ctx.Err() == nil {
 				logger.Error(ctx, "Repository scan todo failed", "error", err)
 			}
@@ -45,9 +60,9 @@ func GetAll(ctx context.Context) ([]models.Todo, error) {
 func GetRange(ctx context.Context, limit, offset int) ([]models.Todo, error) {
 	db := database.DB(ctx)
 	if db == nil {
-		return nil, sql.ErrNoRows
+		return nil, sql.ErrConnDone
 	}
-	query := `SELECT id, title, description, completed, user_id, created_at, updated_at FROM todos ORDER BY created_at DESC`
+	query := `SELECT id, title, description, completed, user_id, created_at, updated_at, version FROM todos ORDER BY created_at DESC`
 	args := []interface{}{}
 	if limit > 0 {
 		query += ` LIMIT $1 OFFSET $2`
@@ -70,7 +85,7 @@ func GetRange(ctx context.Context, limit, offset int) ([]models.Todo, error) {
 	var todos []models.Todo
 	for rows.Next() {
 		var t models.Todo
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.UserID, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.UserID, &t.CreatedAt, &t.UpdatedAt, &t.Version); err != nil {
 			if ctx.Err() == nil {
 				logger.Error(ctx, "Repository scan todo failed", "error", err)
 			}
@@ -81,60 +96,168 @@ func GetRange(ctx context.Context, limit, offset int) ([]models.Todo, error) {
 	return todos, rows.Err()
 }
 
-// Create inserts a new todo.
-func Create(ctx context.Context, todo *models.Todo) error {
+// EncodeCursor returns the opaque cursor pointing just after t in (created_at DESC, id DESC) order.
+func EncodeCursor(t models.Todo) string {
+	raw := t.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + t.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by EncodeCursor.
+func DecodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return createdAt, id, nil
+}
+
+// GetPage returns up to `limit` todos after cursor (empty = first page), seeking on (created_at, id)
+// so deep pages cost the same as the first one. NextCursor is set only when more rows remain.
+func GetPage(ctx context.Context, limit int, cursor string) (*models.TodoPage, error) {
 	db := database.DB(ctx)
 	if db == nil {
-		return sql.ErrNoRows
-	}
-	if todo.ID == "" {
-		todo.ID = uuid.New().String()
-	}
-	now := time.Now()
-	todo.CreatedAt = now
-	todo.UpdatedAt = now
-	_, err := db.ExecContext(ctx,
-		`INSERT INTO todos (id, title, description, completed, user_id, created_at, updated_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		todo.ID, todo.Title, todo.Description, todo.Completed, todo.UserID, todo.CreatedAt, todo.UpdatedAt)
+		return nil, sql.ErrConnDone
+	}
+	query := `SELECT id, title, description, completed, user_id, created_at, updated_at, version FROM todos`
+	// Fetch one extra row to learn whether a next page exists.
+	args := []interface{}{limit + 1}
+	if cursor != "" {
+		createdAt, id, err := DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		// The created_at <= bound lets the planner range-scan idx_todos_created_at; the row comparison breaks ties.
+		query += ` WHERE created_at <= $2 AND (created_at, id) < ($2, $3)`
+		args = append(args, createdAt, id)
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT $1`
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		logger.Error(ctx, "Repository Create failed", "error", err)
-		return err
+		if ctx.Err() == nil {
+			logger.Error(ctx, "Repository GetPage failed", "error", err)
+		}
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+	page := &models.TodoPage{Items: make([]models.Todo, 0, limit)}
+	for rows.Next() {
+		var t models.Todo
+		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.UserID, &t.CreatedAt, &t.UpdatedAt, &t.Version); err != nil {
+			if ctx.Err() == nil {
+				logger.Error(ctx, "Repository scan todo failed", "error", err)
+			}
+			return nil, err
+		}
+		page.Items = append(page.Items, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = EncodeCursor(page.Items[limit-1])
+	}
+	return page, nil
 }
 
-// Update updates an existing todo by ID (and user_id for safety).
-func Update(ctx context.Context, id, userID, title, description string, completed *bool) error {
+// GetByUser returns up to `limit` todos owned by userID, newest first (served by idx_todos_user_id). Use limit=0 for all.
+func GetByUser(ctx context.Context, userID string, limit int) ([]models.Todo, error) {
 	db := database.DB(ctx)
 	if db == nil {
-		return sql.ErrNoRows
-	}
-	now := time.Now()
-	if title != "" || description != "" || completed != nil {
-		_, err := db.ExecContext(ctx,
-			`UPDATE todos SET title = COALESCE(NULLIF($1,''), title), description = COALESCE(NULLIF($2,''), description),
-			 completed = COALESCE($3, completed), updated_at = $4 WHERE id = $5 AND user_id = $6`,
-			title, description, completed, now, id, userID)
-		if err != nil {
-			logger.Error(ctx, "Repository Update failed", "error", err, "id", id)
-			return err
+		return nil, sql.ErrConnDone
+	}
+	query := `SELECT id, title, description, completed, user_id, created_at, updated_at, version FROM todos WHERE user_id = $1 ORDER BY created_at DESC`
+	args := []interface{}{userID}
+	if limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, limit)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error(ctx, "Repository GetByUser failed", "error", err)
 		}
-		return nil
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+	todos := []models.Todo{}
+	for rows.Next() {
+		var t models.Todo
+		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.UserID, &t.CreatedAt, &t.UpdatedAt, &t.Version); err != nil {
+			if ctx.Err() == nil {
+				logger.Error(ctx, "Repository scan todo failed", "error", err)
+			}
+			return nil, err
+		}
+		todos = append(todos, t)
+	}
+	return todos, rows.Err()
 }
 
-// Delete removes a todo by ID and user_id.
-func Delete(ctx context.Context, id, userID string) error {
+// GetByID returns a single todo by id, or ErrNotFound.
+func GetByID(ctx context.Context, id string) (*models.Todo, error) {
 	db := database.DB(ctx)
 	if db == nil {
-		return sql.ErrNoRows
+		return nil, sql.ErrConnDone
+	}
+	var t models.Todo
+	err := db.QueryRowContext(ctx,
+		`SELECT id, title, description, completed, user_id, created_at, updated_at, version FROM todos WHERE id = $1`, id).
+		Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.UserID, &t.CreatedAt, &t.UpdatedAt, &t.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
 	}
-	_, err := db.ExecContext(ctx, `DELETE FROM todos WHERE id = $1 AND user_id = $2`, id, userID)
 	if err != nil {
-		logger.Error(ctx, "Repository Delete failed", "error", err, "id", id)
-		return err
+		if ctx.Err() == nil {
+			logger.Error(ctx, "Repository GetByID failed", "error", err, "id", id)
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DuplicateError is returned by ApplyBatch for a command id that was already processed; Status and Reason are
+// the outcome recorded the first time.
+type DuplicateError struct {
+	CommandID string
+	Status    string
+	Reason    string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("command %s already processed (%s)", e.CommandID, e.Status)
+}
+
+// RejectionStatus maps a rejection (see IsRejection) to its command outcome.
+func RejectionStatus(err error) string {
+	if errors.Is(err, ErrConflict) {
+		return models.CommandConflict
+	}
+	return models.CommandRejected
+}
+
+// CheckOwner returns ErrNotFound for a nil todo, ErrForbidden if it belongs to someone other than userID, nil otherwise.
+func CheckOwner(todo *models.Todo, userID string) error {
+	if todo == nil {
+		return ErrNotFound
+	}
+	if todo.UserID != userID {
+		return ErrForbidden
 	}
 	return nil
 }
+
+// IsRejection reports whether err means the command itself is invalid (unknown id, foreign id, empty update, stale version)
+// rather than that the write failed; retrying or replaying it can never succeed.
+func IsRejection(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) || errors.Is(err, ErrNoChanges) ||
+		errors.Is(err, ErrConflict)
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"million-rps/internal/controller"
+	"million-rps/internal/metrics"
 	"million-rps/internal/middleware"
 
 	"github.com/gin-gonic/gin"
@@ -10,11 +11,12 @@ import (
 func Router() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(gin.Recovery())
+	router.Use(gin.Recovery(), middleware.Metrics())
 
 	// Health for load balancers and K8s probes
 	router.GET("/health", controller.Health)
 	router.GET("/ready", controller.Ready)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	// Public: no auth
 	router.GET("/todos", controller.GetTodos)
@@ -23,9 +25,12 @@ func Router() *gin.Engine {
 	api := router.Group("")
 	api.Use(middleware.AuthMiddleware())
 	{
+		api.GET("/todos/:id", controller.GetTodo)
+		api.GET("/me/todos", controller.GetMyTodos)
 		api.POST("/todos", controller.CreateTodo)
 		api.PUT("/todos/:id", controller.UpdateTodo)
 		api.DELETE("/todos/:id", controller.DeleteTodo)
+		api.GET("/commands/:id", controller.GetCommand)
 	}
 
 	return router
@@ -0,0 +1,178 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"million-rps/internal/cache"
+	"million-rps/internal/controller"
+	"million-rps/internal/models"
+	"million-rps/internal/queue"
+	"million-rps/internal/repository"
+	"million-rps/internal/worker"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+func TestMain(m *testing.M) {
+	// Must be set before config.Get() loads the environment.
+	os.Setenv("JWT_SECRET", testSecret)
+	os.Exit(m.Run())
+}
+
+func token(t *testing.T, subject string) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func call(t *testing.T, h http.Handler, method, path, bearer, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func decodeTodos(t *testing.T, w *httptest.ResponseRecorder) []models.Todo {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var todos []models.Todo
+	if err := json.Unmarshal(w.Body.Bytes(), &todos); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return todos
+}
+
+// TestCreateApplyRead drives the real router and worker against in-memory Postgres/Redis/Kafka stand-ins:
+// POST is queued, the worker applies it and invalidates the cache, and the next GET sees the new todo.
+func TestCreateApplyRead(t *testing.T) {
+	ctx := context.Background()
+	store, tc, q := repository.NewMemory(), cache.NewMemory(), &queue.Memory{}
+	controller.Use(store, tc, q)
+	worker.Use(store, tc, queue.NewChannel(1))
+	t.Cleanup(func() {
+		controller.Use(repository.Postgres{}, cache.Redis{}, queue.Kafka{})
+		worker.Use(repository.Postgres{}, cache.Redis{}, queue.Kafka{})
+	})
+	r := Router()
+	alice := token(t, "alice")
+
+	// Warm the cache with an empty list.
+	if todos := decodeTodos(t, call(t, r, http.MethodGet, "/todos?limit=10", "", "")); len(todos) != 0 {
+		t.Fatalf("initial list = %+v", todos)
+	}
+	deadline := time.Now().Add(time.Second)
+	for _, ok := tc.GetRawTodosLimit(ctx, 10, cache.Identity); !ok; _, ok = tc.GetRawTodosLimit(ctx, 10, cache.Identity) {
+		if time.Now().After(deadline) {
+			t.Fatal("cache was never filled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if w := call(t, r, http.MethodPost, "/todos", "", `{"title":"x"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated POST status = %d", w.Code)
+	}
+	w := call(t, r, http.MethodPost, "/todos", alice, `{"title":"buy milk"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST status = %d, body %s", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+
+	// Nothing applied yet: the cached (empty) list is still served.
+	if todos := decodeTodos(t, call(t, r, http.MethodGet, "/todos?limit=10", "", "")); len(todos) != 0 {
+		t.Fatalf("list before apply = %+v", todos)
+	}
+
+	for _, cmd := range q.Drain() {
+		if err := worker.Process(ctx, &cmd); err != nil {
+			t.Fatalf("worker.Process: %v", err)
+		}
+	}
+
+	todos := decodeTodos(t, call(t, r, http.MethodGet, "/todos?limit=10", "", ""))
+	if len(todos) != 1 || todos[0].Title != "buy milk" || todos[0].UserID != "alice" {
+		t.Fatalf("list after apply = %+v", todos)
+	}
+	if mine := decodeTodos(t, call(t, r, http.MethodGet, "/me/todos", alice, "")); len(mine) != 1 {
+		t.Fatalf("alice's list = %+v", mine)
+	}
+	if theirs := decodeTodos(t, call(t, r, http.MethodGet, "/me/todos", token(t, "bob"), "")); len(theirs) != 0 {
+		t.Fatalf("bob sees %+v", theirs)
+	}
+	if w := call(t, r, http.MethodGet, "/todos/"+todos[0].ID, alice, ""); w.Code != http.StatusOK {
+		t.Fatalf("GET /todos/:id status = %d", w.Code)
+	}
+	if w := call(t, r, http.MethodGet, "/todos/"+todos[0].ID, token(t, "bob"), ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET /todos/:id by another user status = %d, want 404", w.Code)
+	}
+
+	w = call(t, r, http.MethodGet, location, alice, "")
+	var st models.CommandStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil || st.Status != models.CommandApplied {
+		t.Fatalf("GET %s = %d %s", location, w.Code, w.Body)
+	}
+}
+
+// TestInProcessQueue runs the write path on the in-process queue backend (QUEUE_BACKEND=memory): the API
+// publishes to the channel and worker.Run applies the command without any broker.
+func TestInProcessQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store, tc, q := repository.NewMemory(), cache.NewMemory(), queue.NewChannel(16)
+	controller.Use(store, tc, q)
+	worker.Use(store, tc, q)
+	done := make(chan struct{})
+	go func() {
+		worker.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		controller.Use(repository.Postgres{}, cache.Redis{}, queue.Kafka{})
+		worker.Use(repository.Postgres{}, cache.Redis{}, queue.Kafka{})
+	})
+	r := Router()
+	alice := token(t, "alice")
+
+	w := call(t, r, http.MethodPost, "/todos", alice, `{"title":"no broker"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST status = %d, body %s", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var st models.CommandStatus
+		w = call(t, r, http.MethodGet, location, alice, "")
+		if json.Unmarshal(w.Body.Bytes(), &st) == nil && st.Status == models.CommandApplied {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("command never applied: %d %s", w.Code, w.Body)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if todos := decodeTodos(t, call(t, r, http.MethodGet, "/me/todos", alice, "")); len(todos) != 1 || todos[0].Title != "no broker" {
+		t.Fatalf("alice's list = %+v", todos)
+	}
+}
@@ -0,0 +1,149 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/internal/metrics"
+	"million-rps/internal/models"
+	"million-rps/internal/queue"
+	"million-rps/internal/repository"
+	"million-rps/pkg/logger"
+)
+
+// maxBatchSize caps WORKER_BATCH_SIZE so a batch's multi-row statements stay well under Postgres' 65535
+// bind parameter limit.
+const maxBatchSize = 1000
+
+// batchSize returns WORKER_BATCH_SIZE clamped to [1, maxBatchSize].
+func batchSize() int {
+	return min(max(config.Get().WorkerBatchSize, 1), maxBatchSize)
+}
+
+// collect returns first plus whatever else arrives on q until the batch is full or WORKER_BATCH_MS passes.
+func collect(ctx context.Context, q <-chan queue.Message, first queue.Message) []queue.Message {
+	batch := []queue.Message{first}
+	n := batchSize()
+	if n == 1 {
+		return batch
+	}
+	timer := time.NewTimer(time.Duration(config.Get().WorkerBatchWait) * time.Millisecond)
+	defer timer.Stop()
+	for len(batch) < n {
+		select {
+		case msg := <-q:
+			batch = append(batch, msg)
+		case <-timer.C:
+			return batch
+		case <-ctx.Done():
+			return batch
+		}
+	}
+	return batch
+}
+
+// handleBatch applies msgs in one transaction (see repository.ApplyBatch), retrying transient failures like
+// handleMessage, and records every command's outcome. If the batch fails permanently it falls back to
+// handleMessage per message so one bad command cannot hold back the others. Returns a non-nil error only when
+// ctx ends before the batch was settled.
+func handleBatch(ctx context.Context, msgs []queue.Message) error {
+	if len(msgs) == 1 {
+		return handleMessage(ctx, msgs[0])
+	}
+	work := context.WithoutCancel(ctx)
+	cmds := make([]*models.TodoCommand, 0, len(msgs))
+	kept := make([]queue.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		var cmd models.TodoCommand
+		if err := json.Unmarshal(msg.Value, &cmd); err != nil {
+			metrics.WorkerMessage(models.CommandFailed, msg.Partition, lagOf(msg))
+			deadLetter(ctx, work, msg, err, 1)
+			continue
+		}
+		cmds = append(cmds, &cmd)
+		kept = append(kept, msg)
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	maxAttempts := config.Get().WorkerMaxAttempts
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		var results []error
+		if results, err = store.ApplyBatch(work, cmds); err == nil {
+			settleBatch(ctx, work, kept, cmds, results, attempts)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !isTransient(err) {
+			logger.Warn(ctx, "Worker batch failed; applying commands one by one", "error", err, "size", len(kept))
+			for _, msg := range kept {
+				if err := handleMessage(ctx, msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if attempts >= maxAttempts {
+			break
+		}
+		logger.Warn(ctx, "Worker batch failed; retrying", "error", err, "attempt", attempts, "size", len(kept))
+		if !sleep(ctx, backoff(attempts)) {
+			return ctx.Err()
+		}
+	}
+	for i, msg := range kept {
+		todoCache.SetCommandOutcome(work, cmds[i], models.CommandFailed, err.Error())
+		metrics.WorkerMessage(models.CommandFailed, msg.Partition, lagOf(msg))
+		deadLetter(ctx, work, msg, err, attempts)
+	}
+	return nil
+}
+
+// settleBatch records the outcome of each command of a committed batch and invalidates the cache once for
+// everything that was applied.
+func settleBatch(ctx, work context.Context, msgs []queue.Message, cmds []*models.TodoCommand, results []error, attempts int) {
+	var todoIDs, userIDs []string
+	users := make(map[string]bool)
+	for i, cmd := range cmds {
+		msg, res := msgs[i], results[i]
+		dup, isDup := duplicate(res)
+		switch {
+		case res == nil:
+			todoCache.SetCommandOutcome(work, cmd, models.CommandApplied, "")
+			metrics.WorkerMessage(models.CommandApplied, msg.Partition, lagOf(msg))
+			if cmd.Action != "create" {
+				todoIDs = append(todoIDs, cmd.ID)
+			}
+			if !users[cmd.UserID] {
+				users[cmd.UserID] = true
+				userIDs = append(userIDs, cmd.UserID)
+			}
+		case isDup:
+			settleDuplicate(ctx, work, cmd, dup, msg.Partition, lagOf(msg))
+		case repository.IsRejection(res):
+			status := repository.RejectionStatus(res)
+			todoCache.SetCommandOutcome(work, cmd, status, res.Error())
+			metrics.WorkerMessage(status, msg.Partition, lagOf(msg))
+		default:
+			todoCache.SetCommandOutcome(work, cmd, models.CommandFailed, res.Error())
+			metrics.WorkerMessage(models.CommandFailed, msg.Partition, lagOf(msg))
+			deadLetter(ctx, work, msg, res, attempts)
+		}
+	}
+	if len(users) > 0 {
+		todoCache.InvalidateBatch(work, todoIDs, userIDs)
+	}
+}
+
+// lagOf returns how many messages were behind msg on its partition when it was fetched.
+func lagOf(msg queue.Message) int64 {
+	return msg.HighWaterMark - msg.Offset - 1
+}
@@ -0,0 +1,213 @@
+package worker
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"million-rps/internal/config"
+	"million-rps/internal/queue"
+	"million-rps/pkg/logger"
+)
+
+// laneQueue is how many fetched messages may wait per lane; with WORKER_POOL_SIZE lanes it bounds the
+// messages held in memory (and redelivered after a crash).
+const laneQueue = 64
+
+// consumer is the part of queue.Consumer the pipeline uses; tests substitute a fake.
+type consumer interface {
+	FetchMessage(ctx context.Context) (queue.Message, error)
+	CommitMessages(ctx context.Context, msgs ...queue.Message) error
+}
+
+// poolSize returns the number of concurrent lanes (WORKER_POOL_SIZE, at least 1).
+func poolSize() int {
+	return max(config.Get().WorkerPoolSize, 1)
+}
+
+// consume fetches messages and fans them out to a fixed pool of lanes. A lane is chosen by message key, so
+// every command for one todo (or user, see KAFKA_PARTITION_KEY) is applied sequentially in offset order
+// while unrelated commands, even on the same partition, run in parallel. Offsets are committed per
+// partition only up to the last message whose predecessors are all settled. consume returns once ctx is
+// cancelled, every lane has settled its current message, and the final offsets are committed.
+func consume(ctx context.Context, r consumer) {
+	offsets := newTracker()
+	commits := make(chan queue.Message, poolSize())
+	committed := make(chan struct{})
+	go func() {
+		defer close(committed)
+		commitLoop(ctx, r, commits)
+	}()
+
+	lanes := make([]chan queue.Message, poolSize())
+	var wg sync.WaitGroup
+	for i := range lanes {
+		lanes[i] = make(chan queue.Message, laneQueue)
+		wg.Add(1)
+		go func(q <-chan queue.Message) {
+			defer wg.Done()
+			runLane(ctx, q, offsets, commits)
+		}(lanes[i])
+	}
+	defer func() {
+		wg.Wait()
+		close(commits)
+		<-committed
+	}()
+
+	failures := 0
+	for {
+		msg, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// Back off while the queue is unreachable instead of spinning on the error.
+			failures++
+			logger.Error(ctx, "Worker fetch failed", "error", err, "attempt", failures)
+			if !sleep(ctx, backoff(failures)) {
+				return
+			}
+			continue
+		}
+		failures = 0
+		offsets.track(msg)
+		select {
+		case lanes[laneFor(msg, len(lanes))] <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// laneFor hashes the message key (falling back to the partition for unkeyed messages) onto a lane.
+func laneFor(msg queue.Message, n int) int {
+	if len(msg.Key) == 0 {
+		return msg.Partition % n
+	}
+	h := fnv.New32a()
+	h.Write(msg.Key)
+	return int(h.Sum32() % uint32(n))
+}
+
+// runLane applies its messages in batches (see collect) until ctx is cancelled, reporting each settled
+// message to the tracker. Queued messages are left unsettled on shutdown, so their offsets (and everything after
+// them in the partition) are not committed and the next owner of the partition redelivers them.
+func runLane(ctx context.Context, q <-chan queue.Message, offsets *tracker, commits chan<- queue.Message) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-q:
+			if ctx.Err() != nil {
+				return
+			}
+			batch := collect(ctx, q, msg)
+			if err := handleBatch(ctx, batch); err != nil {
+				// Only returned on shutdown mid-retry: leave the offsets unsettled so the messages are redelivered.
+				return
+			}
+			for _, m := range batch {
+				if upTo, ok := offsets.settle(m); ok {
+					commits <- upTo
+				}
+			}
+		}
+	}
+}
+
+// commitLoop commits the offsets sent by the lanes, coalescing whatever is queued into one request and
+// never moving a partition backwards. It returns after commits is closed and drained.
+func commitLoop(ctx context.Context, r consumer, commits <-chan queue.Message) {
+	// Commit even if ctx was cancelled while the last messages were being applied.
+	work := context.WithoutCancel(ctx)
+	last := make(map[int]int64)
+	for msg := range commits {
+		latest := map[int]queue.Message{msg.Partition: msg}
+	drain:
+		for {
+			select {
+			case m, ok := <-commits:
+				if !ok {
+					break drain
+				}
+				if cur, seen := latest[m.Partition]; !seen || m.Offset > cur.Offset {
+					latest[m.Partition] = m
+				}
+			default:
+				break drain
+			}
+		}
+		batch := make([]queue.Message, 0, len(latest))
+		for p, m := range latest {
+			if off, seen := last[p]; !seen || m.Offset > off {
+				batch = append(batch, m)
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := r.CommitMessages(work, batch...); err != nil {
+			logger.Error(ctx, "Worker commit failed", "error", err)
+			continue
+		}
+		for _, m := range batch {
+			last[m.Partition] = m.Offset
+		}
+	}
+}
+
+// tracker records fetched offsets per partition so commits only cover messages whose predecessors are
+// all settled, even though lanes finish them out of order. An offset fetched again while still in flight
+// (redelivery after a rebalance) is tracked once per fetch and needs one settle per fetch.
+type tracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	pending []queue.Message // fetched and not yet committable, in offset order (payload dropped)
+	settled map[int64]int   // per offset in pending, how many of its fetches lanes have finished
+}
+
+func newTracker() *tracker {
+	return &tracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+// track registers msg as in flight. Call in fetch order, before handing msg to a lane.
+func (t *tracker) track(msg queue.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.partitions[msg.Partition]
+	if p == nil {
+		p = &partitionOffsets{settled: make(map[int64]int)}
+		t.partitions[msg.Partition] = p
+	}
+	p.pending = append(p.pending, queue.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset})
+}
+
+// settle marks msg done and returns the highest message of its partition that can now be committed,
+// if settling msg closed the gap in front of it.
+func (t *tracker) settle(msg queue.Message) (queue.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.partitions[msg.Partition]
+	if p == nil {
+		return queue.Message{}, false
+	}
+	p.settled[msg.Offset]++
+	n := 0
+	for n < len(p.pending) && p.settled[p.pending[n].Offset] > 0 {
+		off := p.pending[n].Offset
+		if p.settled[off]--; p.settled[off] == 0 {
+			delete(p.settled, off)
+		}
+		n++
+	}
+	if n == 0 {
+		return queue.Message{}, false
+	}
+	upTo := p.pending[n-1]
+	p.pending = p.pending[n:]
+	return upTo, true
+}
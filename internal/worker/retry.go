@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"million-rps/internal/config"
+
+	"github.com/lib/pq"
+)
+
+// isTransient reports whether err is likely to succeed on retry (DB unreachable, connection drops,
+// deadlines, serialization failures). Everything else is treated as permanent and dead-lettered.
+func isTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"40", // transaction rollback (serialization failure, deadlock)
+			"53", // insufficient resources (too many connections, disk full)
+			"57": // operator intervention (admin shutdown, cannot connect now)
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before retry number `attempt` (1-based): base * 2^(attempt-1), capped.
+func backoff(attempt int) time.Duration {
+	cfg := config.Get()
+	d := time.Duration(cfg.WorkerRetryBackoff) * time.Millisecond
+	max := time.Duration(cfg.WorkerRetryMaxBackoff) * time.Millisecond
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// sleep waits for d or until ctx is done. Returns false if ctx ended first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
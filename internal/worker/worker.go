@@ -3,96 +3,184 @@ package worker
 import (
 	"context"
 	"encoding/json"
-	"strings"
-	"sync/atomic"
+	"errors"
+	"time"
 
 	"million-rps/internal/cache"
 	"million-rps/internal/config"
+	"million-rps/internal/metrics"
 	"million-rps/internal/models"
 	"million-rps/internal/queue"
 	"million-rps/internal/repository"
 	"million-rps/pkg/logger"
+)
 
-	"github.com/segmentio/kafka-go"
+// Worker dependencies. Defaults are the production Postgres, Redis and Kafka implementations; tests swap them with Use.
+var (
+	store     repository.TodoStore = repository.Postgres{}
+	todoCache cache.TodoCache      = cache.Redis{}
+	commands  queue.CommandQueue   = queue.Kafka{}
 )
 
-// Run starts the Kafka consumer: reads todo commands, applies to DB, invalidates cache.
-// One consumer per process; scale by running more replicas (consumer group shares partitions).
+// Use replaces the store and cache the worker applies commands to and the queue it consumes. Call before Run.
+func Use(s repository.TodoStore, tc cache.TodoCache, q queue.CommandQueue) {
+	store, todoCache, commands = s, tc, q
+}
+
+// Run consumes todo commands from the configured queue, applies them to the DB and invalidates the cache
+// until ctx is cancelled. One consumer per process; scale by running more replicas (with Kafka the consumer
+// group shares partitions).
 func Run(ctx context.Context) {
-	cfg := config.Get()
-	if cfg.KafkaBrokers == "" {
-		logger.Info(ctx, "Worker disabled (no Kafka brokers)")
-		return
-	}
-	topic := queue.Topic()
-	brokers := cfg.KafkaBrokers
-	if brokers == "" {
+	c, err := commands.Consumer(ctx)
+	if err != nil {
+		logger.Error(ctx, "Worker consumer failed to start", "error", err)
 		return
 	}
+	defer c.Close()
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  strings.Split(cfg.KafkaBrokers, ","),
-		Topic:    topic,
-		GroupID:  "todo-workers",
-		MinBytes: 1,
-		MaxBytes: 10e6,
-	})
-	defer reader.Close()
+	logger.Info(ctx, "Worker started", "pool", poolSize())
+	go pruneProcessed(ctx)
+	consume(ctx, c)
+}
 
-	var processed int64
-	logger.Info(ctx, "Kafka consumer started", "topic", topic)
+// pruneProcessed drops processed command ids older than PROCESSED_COMMANDS_RETENTION_HOURS once an hour
+// until ctx is cancelled. Every replica runs it; the DELETE is idempotent.
+func pruneProcessed(ctx context.Context) {
+	age := time.Duration(config.Get().ProcessedRetention) * time.Hour
+	if age <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
 	for {
-		msg, err := reader.FetchMessage(ctx)
-		if err != nil {
-			if ctx.Err() != nil {
-				return
-			}
-			logger.Error(ctx, "Worker fetch failed", "error", err)
-			continue
+		if n, err := store.PruneProcessed(ctx, age); err == nil && n > 0 {
+			logger.Info(ctx, "Pruned processed commands", "rows", n)
 		}
-		if err := handleMessage(ctx, msg.Value); err != nil {
-			logger.Error(ctx, "Worker handle failed", "error", err, "payload", string(msg.Value))
-			// Commit anyway to avoid poison pill blocking the partition
-			_ = reader.CommitMessages(ctx, msg)
-			continue
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-		if err := reader.CommitMessages(ctx, msg); err != nil {
-			logger.Error(ctx, "Worker commit failed", "error", err)
-		}
-		atomic.AddInt64(&processed, 1)
 	}
 }
 
-func handleMessage(ctx context.Context, payload []byte) error {
+// handleMessage applies one message, retrying transient failures with backoff. Messages that still fail
+// (or can never succeed, e.g. bad payloads) are copied to the dead-letter topic so the partition keeps moving.
+// ctx only stops retries: an apply already in flight runs to completion so shutdown does not abort a write
+// half-way. Returns a non-nil error only when ctx ends before the message was settled.
+func handleMessage(ctx context.Context, msg queue.Message) error {
+	work := context.WithoutCancel(ctx)
 	var cmd models.TodoCommand
-	if err := json.Unmarshal(payload, &cmd); err != nil {
-		return err
+	lag := lagOf(msg)
+	if err := json.Unmarshal(msg.Value, &cmd); err != nil {
+		metrics.WorkerMessage(models.CommandFailed, msg.Partition, lag)
+		deadLetter(ctx, work, msg, err, 1)
+		return nil
 	}
-	switch cmd.Action {
-	case "create":
-		todo := &models.Todo{
-			ID:          cmd.ID,
-			Title:       cmd.Title,
-			Description: cmd.Description,
-			UserID:      cmd.UserID,
+	maxAttempts := config.Get().WorkerMaxAttempts
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		if err = applyCommand(work, &cmd); err == nil {
+			todoCache.SetCommandOutcome(work, &cmd, models.CommandApplied, "")
+			metrics.WorkerMessage(models.CommandApplied, msg.Partition, lag)
+			return nil
+		}
+		if dup, ok := duplicate(err); ok {
+			settleDuplicate(ctx, work, &cmd, dup, msg.Partition, lag)
+			return nil
+		}
+		if repository.IsRejection(err) {
+			// Nothing to retry or dead-letter: the command is settled as rejected (or conflict).
+			status := repository.RejectionStatus(err)
+			todoCache.SetCommandOutcome(work, &cmd, status, err.Error())
+			metrics.WorkerMessage(status, msg.Partition, lag)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-		if cmd.Completed != nil {
-			todo.Completed = *cmd.Completed
+		if !isTransient(err) || attempts >= maxAttempts {
+			break
 		}
-		if err := repository.Create(ctx, todo); err != nil {
-			return err
+		logger.Warn(ctx, "Worker apply failed; retrying", "error", err, "attempt", attempts, "command_id", cmd.CommandID)
+		if !sleep(ctx, backoff(attempts)) {
+			return ctx.Err()
 		}
-	case "update":
-		if err := repository.Update(ctx, cmd.ID, cmd.UserID, cmd.Title, cmd.Description, cmd.Completed); err != nil {
-			return err
+	}
+	todoCache.SetCommandOutcome(work, &cmd, models.CommandFailed, err.Error())
+	metrics.WorkerMessage(models.CommandFailed, msg.Partition, lag)
+	deadLetter(ctx, work, msg, err, attempts)
+	return nil
+}
+
+// deadLetter publishes msg to the DLQ (using work), retrying transient broker errors until ctx ends. If that still
+// fails the message is logged in full and dropped, matching the pre-DLQ behaviour.
+func deadLetter(ctx, work context.Context, msg queue.Message, cause error, attempts int) {
+	logger.Error(ctx, "Worker dead-lettering message", "error", cause, "attempts", attempts,
+		"partition", msg.Partition, "offset", msg.Offset)
+	for i := 1; ; i++ {
+		err := commands.PublishDeadLetter(work, msg, cause, attempts)
+		if err == nil {
+			return
 		}
-	case "delete":
-		if err := repository.Delete(ctx, cmd.ID, cmd.UserID); err != nil {
-			return err
+		if i >= config.Get().WorkerMaxAttempts || !sleep(ctx, backoff(i)) {
+			logger.Error(ctx, "Worker DLQ publish failed; dropping message", "error", err, "payload", string(msg.Value))
+			return
 		}
-	default:
+	}
+}
+
+// Process applies a single command once (no retries or dead-lettering) and records its outcome. For tests
+// that apply commands without a consumer (the router tests); queues, including the in-process one, are
+// consumed by Run. A command that was already processed is not applied again and returns nil.
+func Process(ctx context.Context, cmd *models.TodoCommand) error {
+	err := applyCommand(ctx, cmd)
+	if dup, ok := duplicate(err); ok {
+		todoCache.SetCommandOutcome(ctx, cmd, dup.Status, dup.Reason)
 		return nil
 	}
-	cache.InvalidateTodos(ctx)
+	if err != nil {
+		status := models.CommandFailed
+		if repository.IsRejection(err) {
+			status = repository.RejectionStatus(err)
+		}
+		todoCache.SetCommandOutcome(ctx, cmd, status, err.Error())
+		return err
+	}
+	todoCache.SetCommandOutcome(ctx, cmd, models.CommandApplied, "")
+	return nil
+}
+
+// duplicate reports whether err means the command was already processed (a redelivered message).
+func duplicate(err error) (*repository.DuplicateError, bool) {
+	var dup *repository.DuplicateError
+	return dup, errors.As(err, &dup)
+}
+
+// settleDuplicate re-records the first outcome of a redelivered command (in case the worker died before
+// recording it) without touching the DB or cache again.
+func settleDuplicate(ctx, work context.Context, cmd *models.TodoCommand, dup *repository.DuplicateError, partition int, lag int64) {
+	logger.Info(ctx, "Worker skipped already processed command", "command_id", cmd.CommandID, "status", dup.Status)
+	todoCache.SetCommandOutcome(work, cmd, dup.Status, dup.Reason)
+	metrics.WorkerMessage(metrics.OutcomeDuplicate, partition, lag)
+}
+
+// applyCommand writes one command to the DB and invalidates the affected cache keys. It goes through
+// ApplyBatch so a redelivered command is recognised by its id (see repository.DuplicateError).
+func applyCommand(ctx context.Context, cmd *models.TodoCommand) error {
+	results, err := store.ApplyBatch(ctx, []*models.TodoCommand{cmd})
+	if err != nil {
+		return err
+	}
+	if err := results[0]; err != nil {
+		return err
+	}
+	if cmd.Action != "create" {
+		todoCache.InvalidateTodo(ctx, cmd.ID)
+	}
+	todoCache.InvalidateTodos(ctx)
+	todoCache.InvalidateUserTodos(ctx, cmd.UserID)
 	return nil
 }
@@ -0,0 +1,396 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"million-rps/internal/cache"
+	"million-rps/internal/config"
+	"million-rps/internal/models"
+	"million-rps/internal/queue"
+	"million-rps/internal/repository"
+
+	"github.com/lib/pq"
+)
+
+func useMemory(t *testing.T) (*repository.Memory, *cache.Memory) {
+	t.Helper()
+	s, c := repository.NewMemory(), cache.NewMemory()
+	Use(s, c, queue.NewChannel(16))
+	t.Cleanup(func() { Use(repository.Postgres{}, cache.Redis{}, queue.Kafka{}) })
+	return s, c
+}
+
+func TestProcessAppliesCommandsAndInvalidates(t *testing.T) {
+	ctx := context.Background()
+	s, c := useMemory(t)
+	done := true
+
+	c.SetRawTodosLimitAsync(0, 10, []byte("stale"))
+	c.SetRawUserTodosAsync(0, "u", 0, []byte("stale"))
+	if err := Process(ctx, &models.TodoCommand{CommandID: "c1", Action: "create", ID: "t1", Title: "a", UserID: "u"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.GetRawTodosLimit(ctx, 10, cache.Identity); ok {
+		t.Error("create did not invalidate todos:limit:10")
+	}
+	if _, ok := c.GetRawUserTodos(ctx, "u", 0, cache.Identity); ok {
+		t.Error("create did not invalidate the user's list")
+	}
+
+	c.SetRawTodoAsync(0, "t1", []byte("stale"))
+	if err := Process(ctx, &models.TodoCommand{CommandID: "c2", Action: "update", ID: "t1", Completed: &done, UserID: "u"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.GetRawTodo(ctx, "t1"); ok {
+		t.Error("update did not invalidate todo:t1")
+	}
+	got, err := s.GetByID(ctx, "t1")
+	if err != nil || !got.Completed {
+		t.Fatalf("after update got %+v, %v", got, err)
+	}
+
+	if err := Process(ctx, &models.TodoCommand{CommandID: "c3", Action: "delete", ID: "t1", UserID: "u"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetByID(ctx, "t1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("after delete err = %v, want ErrNotFound", err)
+	}
+	for _, id := range []string{"c1", "c2", "c3"} {
+		if st, ok := c.GetCommandStatus(ctx, id); !ok || st.Status != models.CommandApplied {
+			t.Errorf("command %s status = %+v, want applied", id, st)
+		}
+	}
+}
+
+func TestProcessRecordsFailure(t *testing.T) {
+	ctx := context.Background()
+	_, c := useMemory(t)
+	if err := Process(ctx, &models.TodoCommand{CommandID: "c1", Action: "archive", ID: "t1", UserID: "u"}); err == nil {
+		t.Fatal("unknown action should fail")
+	}
+	st, ok := c.GetCommandStatus(ctx, "c1")
+	if !ok || st.Status != models.CommandFailed || st.Reason == "" {
+		t.Fatalf("status = %+v, want failed with reason", st)
+	}
+}
+
+func TestProcessRecordsRejection(t *testing.T) {
+	ctx := context.Background()
+	s, c := useMemory(t)
+	_ = s.Create(ctx, &models.Todo{ID: "t1", Title: "a", UserID: "alice"})
+
+	err := Process(ctx, &models.TodoCommand{CommandID: "c1", Action: "delete", ID: "t1", UserID: "bob"})
+	if !errors.Is(err, repository.ErrForbidden) {
+		t.Fatalf("err = %v, want ErrForbidden", err)
+	}
+	st, ok := c.GetCommandStatus(ctx, "c1")
+	if !ok || st.Status != models.CommandRejected {
+		t.Fatalf("status = %+v, want rejected", st)
+	}
+	if _, err := s.GetByID(ctx, "t1"); err != nil {
+		t.Fatalf("foreign delete removed the todo: %v", err)
+	}
+}
+
+func TestProcessRejectsStaleVersionAsConflict(t *testing.T) {
+	ctx := context.Background()
+	s, c := useMemory(t)
+	_ = s.Create(ctx, &models.Todo{ID: "t1", Title: "a", UserID: "u"})
+	v1 := int64(1)
+
+	// Two clients read version 1; the first update wins, the second is stale.
+	if err := Process(ctx, &models.TodoCommand{CommandID: "c1", Action: "update", ID: "t1", Title: "first", UserID: "u", ExpectedVersion: &v1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Process(ctx, &models.TodoCommand{CommandID: "c2", Action: "update", ID: "t1", Title: "second", UserID: "u", ExpectedVersion: &v1}); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("stale update err = %v, want ErrConflict", err)
+	}
+	if st, _ := c.GetCommandStatus(ctx, "c2"); st == nil || st.Status != models.CommandConflict {
+		t.Fatalf("status = %+v, want conflict", st)
+	}
+	if got, _ := s.GetByID(ctx, "t1"); got.Title != "first" {
+		t.Fatalf("title = %q, want first", got.Title)
+	}
+}
+
+func TestRedeliveredCommandIsAppliedOnce(t *testing.T) {
+	ctx := context.Background()
+	s, c := useMemory(t)
+	create := models.TodoCommand{CommandID: "c1", Action: "create", ID: "t1", Title: "a", UserID: "u"}
+	update := models.TodoCommand{CommandID: "c2", Action: "update", ID: "t1", Title: "b", UserID: "u"}
+	for _, cmd := range []models.TodoCommand{create, update} {
+		b, _ := json.Marshal(cmd)
+		// Simulates a crash between the DB commit and the offset commit: the message is delivered again.
+		for i := 0; i < 2; i++ {
+			if err := handleMessage(ctx, queue.Message{Value: b}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if got, _ := s.GetByID(ctx, "t1"); got == nil || got.Title != "b" || got.Version != 2 {
+		t.Fatalf("t1 = %+v, want title b at version 2", got)
+	}
+	if st, ok := c.GetCommandStatus(ctx, "c2"); !ok || st.Status != models.CommandApplied {
+		t.Fatalf("c2 status = %+v, want applied", st)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{context.DeadlineExceeded, true},
+		{fmt.Errorf("wrapped: %w", sql.ErrConnDone), true},
+		{&pq.Error{Code: "08006"}, true},  // connection_failure
+		{&pq.Error{Code: "40001"}, true},  // serialization_failure
+		{&pq.Error{Code: "23505"}, false}, // unique_violation
+		{errors.New("unknown action"), false},
+	}
+	for _, tc := range cases {
+		if got := isTransient(tc.err); got != tc.want {
+			t.Errorf("isTransient(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestBackoffDoublesAndCaps(t *testing.T) {
+	base, max := backoff(1), backoff(100)
+	if backoff(2) != 2*base {
+		t.Errorf("backoff(2) = %v, want %v", backoff(2), 2*base)
+	}
+	if max <= base || max > 10*time.Minute {
+		t.Errorf("backoff(100) = %v, want capped above base %v", max, base)
+	}
+	if backoff(101) != max {
+		t.Errorf("backoff not capped: %v != %v", backoff(101), max)
+	}
+}
+
+// fakeConsumer serves queued messages and records commits per partition.
+type fakeConsumer struct {
+	msgs chan queue.Message
+
+	mu      sync.Mutex
+	commits map[int][]int64
+}
+
+func (f *fakeConsumer) FetchMessage(ctx context.Context) (queue.Message, error) {
+	select {
+	case m := <-f.msgs:
+		return m, nil
+	case <-ctx.Done():
+		return queue.Message{}, ctx.Err()
+	}
+}
+
+func (f *fakeConsumer) CommitMessages(ctx context.Context, msgs ...queue.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range msgs {
+		f.commits[m.Partition] = append(f.commits[m.Partition], m.Offset)
+	}
+	return nil
+}
+
+// committedThrough reports whether every partition has committed up to the given last offsets.
+func (f *fakeConsumer) committedThrough(last map[int]int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for p, off := range last {
+		got := f.commits[p]
+		if len(got) == 0 || got[len(got)-1] != off {
+			return false
+		}
+	}
+	return true
+}
+
+// failingConsumer fails every fetch, like a consumer whose broker is down.
+type failingConsumer struct {
+	fetches atomic.Int32
+}
+
+func (f *failingConsumer) FetchMessage(ctx context.Context) (queue.Message, error) {
+	f.fetches.Add(1)
+	return queue.Message{}, errors.New("broker down")
+}
+
+func (f *failingConsumer) CommitMessages(ctx context.Context, msgs ...queue.Message) error {
+	return nil
+}
+
+func TestConsumeBacksOffWhileFetchFails(t *testing.T) {
+	cfg := config.Get()
+	prevBase, prevMax := cfg.WorkerRetryBackoff, cfg.WorkerRetryMaxBackoff
+	t.Cleanup(func() { cfg.WorkerRetryBackoff, cfg.WorkerRetryMaxBackoff = prevBase, prevMax })
+	cfg.WorkerRetryBackoff, cfg.WorkerRetryMaxBackoff = 20, 1000
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	f := &failingConsumer{}
+	consume(ctx, f)
+	// 20ms + 40ms + 80ms of backoff fit at most three or four fetches into 100ms.
+	if n := f.fetches.Load(); n > 4 {
+		t.Fatalf("%d fetches in 100ms, want backoff between failures", n)
+	}
+}
+
+func TestConsumeAppliesEachTodoInOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, c := useMemory(t)
+	prev := config.Get().WorkerPoolSize
+	config.Get().WorkerPoolSize = 8
+	t.Cleanup(func() { config.Get().WorkerPoolSize = prev })
+
+	// create/update/delete of each todo share a key and partition, as with KAFKA_PARTITION_KEY=todo.
+	const partitions, todos = 4, 50
+	f := &fakeConsumer{msgs: make(chan queue.Message, 3*todos), commits: map[int][]int64{}}
+	last := map[int]int64{}
+	next := make([]int64, partitions)
+	done := true
+	for i := 0; i < todos; i++ {
+		id := fmt.Sprintf("t%d", i)
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		p := int(h.Sum32() % partitions)
+		for j, cmd := range []models.TodoCommand{
+			{Action: "create", Title: "a"},
+			{Action: "update", Completed: &done},
+			{Action: "delete"},
+		} {
+			cmd.CommandID, cmd.ID, cmd.UserID = fmt.Sprintf("%s-%d", id, j), id, "u"
+			b, _ := json.Marshal(cmd)
+			f.msgs <- queue.Message{Partition: p, Offset: next[p], Key: []byte(id), Value: b}
+			last[p] = next[p]
+			next[p]++
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		consume(ctx, f)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !f.committedThrough(last) {
+		if time.Now().After(deadline) {
+			t.Fatalf("commits = %v, want through %v", f.commits, last)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-stopped
+
+	for p, got := range f.commits {
+		for i := 1; i < len(got); i++ {
+			if got[i] <= got[i-1] {
+				t.Fatalf("partition %d commits = %v, want increasing offsets", p, got)
+			}
+		}
+	}
+	for i := 0; i < todos; i++ {
+		for j := 0; j < 3; j++ {
+			id := fmt.Sprintf("t%d-%d", i, j)
+			if st, ok := c.GetCommandStatus(ctx, id); !ok || st.Status != models.CommandApplied {
+				t.Fatalf("command %s status = %+v, want applied", id, st)
+			}
+		}
+	}
+	if all, _ := s.GetAll(ctx); len(all) != 0 {
+		t.Fatalf("%d todos left after deletes (applied out of order)", len(all))
+	}
+}
+
+func TestTrackerCommitsOnlyContiguousOffsets(t *testing.T) {
+	tr := newTracker()
+	msgs := make([]queue.Message, 4)
+	for i := range msgs {
+		msgs[i] = queue.Message{Partition: 2, Offset: int64(10 + i)}
+		tr.track(msgs[i])
+	}
+	if _, ok := tr.settle(msgs[2]); ok {
+		t.Fatal("offset 12 committable while 10 and 11 are in flight")
+	}
+	if _, ok := tr.settle(msgs[1]); ok {
+		t.Fatal("offset 11 committable while 10 is in flight")
+	}
+	if upTo, ok := tr.settle(msgs[0]); !ok || upTo.Offset != 12 {
+		t.Fatalf("settle(10) = %d, %v; want commit through 12", upTo.Offset, ok)
+	}
+	if upTo, ok := tr.settle(msgs[3]); !ok || upTo.Offset != 13 {
+		t.Fatalf("settle(13) = %d, %v; want commit through 13", upTo.Offset, ok)
+	}
+}
+
+func TestTrackerSettlesRedeliveredInFlightOffsets(t *testing.T) {
+	tr := newTracker()
+	first, second := queue.Message{Partition: 1, Offset: 5}, queue.Message{Partition: 1, Offset: 6}
+	tr.track(first)
+	tr.track(second)
+	// A rebalance hands offset 5 out again while the first copy is still in a lane.
+	tr.track(first)
+	tr.track(second)
+
+	// Both copies of 6 finish before either copy of 5.
+	for range 2 {
+		if _, ok := tr.settle(second); ok {
+			t.Fatal("offset 6 committable while 5 is in flight")
+		}
+	}
+	if upTo, ok := tr.settle(first); !ok || upTo.Offset != 6 {
+		t.Fatalf("settle(5) = %d, %v; want commit through 6", upTo.Offset, ok)
+	}
+	if upTo, ok := tr.settle(first); !ok || upTo.Offset != 6 {
+		t.Fatalf("second settle(5) = %d, %v; want commit through the second fetch of 6", upTo.Offset, ok)
+	}
+	if p := tr.partitions[1]; len(p.pending) != 0 || len(p.settled) != 0 {
+		t.Fatalf("tracker not drained: %d pending, %d settled", len(p.pending), len(p.settled))
+	}
+}
+
+func TestHandleBatchRecordsEachOutcome(t *testing.T) {
+	ctx := context.Background()
+	s, c := useMemory(t)
+	_ = s.Create(ctx, &models.Todo{ID: "t0", Title: "x", UserID: "u"})
+	c.SetRawTodosLimitAsync(0, 10, []byte("stale"))
+	c.SetRawTodoAsync(0, "t0", []byte("stale"))
+
+	var msgs []queue.Message
+	for i, cmd := range []models.TodoCommand{
+		{Action: "create", ID: "t1", Title: "a", UserID: "u"},
+		{Action: "update", ID: "t0", Title: "y", UserID: "u"},
+		{Action: "delete", ID: "missing", UserID: "u"},
+	} {
+		cmd.CommandID = fmt.Sprintf("c%d", i)
+		b, _ := json.Marshal(cmd)
+		msgs = append(msgs, queue.Message{Offset: int64(i), Value: b})
+	}
+	if err := handleBatch(ctx, msgs); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]string{"c0": models.CommandApplied, "c1": models.CommandApplied, "c2": models.CommandRejected} {
+		if st, ok := c.GetCommandStatus(ctx, id); !ok || st.Status != want {
+			t.Errorf("command %s status = %+v, want %s", id, st, want)
+		}
+	}
+	if _, ok := c.GetRawTodosLimit(ctx, 10, cache.Identity); ok {
+		t.Error("batch did not invalidate todos:limit:10")
+	}
+	if _, ok := c.GetRawTodo(ctx, "t0"); ok {
+		t.Error("batch did not invalidate todo:t0")
+	}
+	if got, _ := s.GetByID(ctx, "t0"); got == nil || got.Title != "y" {
+		t.Fatalf("t0 = %+v, want title y", got)
+	}
+}
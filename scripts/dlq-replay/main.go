@@ -0,0 +1,126 @@
+// DLQ replay re-publishes dead-lettered todo commands to the main topic once the cause is fixed.
+// Run from project root: go run ./scripts/dlq-replay [-max N] [-idle 5s] [-dry-run]
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"million-rps/internal/config"
+	"million-rps/internal/queue"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func main() {
+	loadEnvFile(".env")
+
+	max := flag.Int("max", 0, "stop after replaying this many messages (0 = until the DLQ is drained)")
+	idle := flag.Duration("idle", 5*time.Second, "stop when no DLQ message arrives for this long")
+	dryRun := flag.Bool("dry-run", false, "print messages without re-publishing or committing them")
+	flag.Parse()
+
+	cfg := config.Get()
+	brokers := queue.Brokers()
+	if len(brokers) == 0 || cfg.KafkaDLQTopic == "" {
+		fmt.Fprintln(os.Stderr, "KAFKA_BROKERS and KAFKA_DLQ_TOPIC must be set")
+		os.Exit(1)
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  brokers,
+		Dialer:   queue.Dialer(),
+		Topic:    cfg.KafkaDLQTopic,
+		GroupID:  "todo-dlq-replay",
+		MinBytes: 1,
+		MaxBytes: 10e6,
+	})
+	defer reader.Close()
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Transport:    queue.Transport(),
+		Topic:        cfg.KafkaTopic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+	defer writer.Close()
+
+	replayed := 0
+	for *max == 0 || replayed < *max {
+		ctx, cancel := context.WithTimeout(context.Background(), *idle)
+		msg, err := reader.FetchMessage(ctx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Fetch failed:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("partition=%s offset=%s attempts=%s error=%q\n",
+			header(msg, queue.HeaderOriginalPartition), header(msg, queue.HeaderOriginalOffset),
+			header(msg, queue.HeaderAttempts), header(msg, queue.HeaderError))
+		if *dryRun {
+			replayed++
+			continue
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		err = writer.WriteMessages(ctx, kafka.Message{Key: msg.Key, Value: msg.Value})
+		if err == nil {
+			err = reader.CommitMessages(ctx, msg)
+		}
+		cancel()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Replay failed:", err)
+			os.Exit(1)
+		}
+		replayed++
+	}
+
+	fmt.Printf("Done: %d messages replayed from %s to %s\n", replayed, cfg.KafkaDLQTopic, cfg.KafkaTopic)
+}
+
+func header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func loadEnvFile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:idx])
+		val := strings.TrimSpace(line[idx+1:])
+		if strings.HasPrefix(val, `"`) && strings.HasSuffix(val, `"`) {
+			val = strings.Trim(val, `"`)
+		} else if strings.HasPrefix(val, "'") && strings.HasSuffix(val, "'") {
+			val = strings.Trim(val, "'")
+		}
+		if key != "" && os.Getenv(key) == "" {
+			_ = os.Setenv(key, val)
+		}
+	}
+}
@@ -24,7 +24,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := database.MigrateOrCreateSchema(ctx); err != nil {
+	if err := database.Migrate(ctx); err != nil {
 		fmt.Fprintln(os.Stderr, "Schema failed:", err)
 		os.Exit(1)
 	}